@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Allergens follow the 14 allergens that EU food labelling requires.
+var knownAllergens = []string{
+	"celery", "crustaceans", "dairy", "egg", "fish", "gluten", "lupin",
+	"molluscs", "mustard", "nuts", "peanuts", "sesame", "soy", "sulphites",
+}
+
+// Diet flags mark what an ingredient is made of: "meat" and "fish" for flesh,
+// "animal" for other animal products such as dairy, eggs or honey.
+var knownDietFlags = []string{"animal", "fish", "meat"}
+
+// A diet is followed when none of its excluded diet flags or allergens occur
+// in the recipe. Names match the tags used on recipes, compared without case.
+type diet struct {
+	Name              string
+	ExcludedFlags     []string
+	ExcludedAllergens []string
+}
+
+var diets = []diet{
+	{Name: "Vegan", ExcludedFlags: []string{"meat", "fish", "animal"}},
+	{Name: "Vegetarian", ExcludedFlags: []string{"meat", "fish"}},
+	{Name: "Pescatarian", ExcludedFlags: []string{"meat"}},
+	{Name: "Gluten-Free", ExcludedAllergens: []string{"gluten"}},
+	{Name: "Dairy-Free", ExcludedAllergens: []string{"dairy"}},
+}
+
+type IngredientClassification struct {
+	Allergens []string `json:"allergens"`
+	DietFlags []string `json:"diet_flags"`
+}
+
+// Handler functions
+func ingredientClassificationHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/ingredients/<id>/classification/")
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid ingredient ID", http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	err = db.QueryRow("SELECT name FROM ingredients WHERE id = ?", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get ingredient", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// Nothing to change, the classification is returned below
+
+	case http.MethodPut:
+		if _, ok := requireUser(w, r); !ok {
+			return
+		}
+		var classification IngredientClassification
+		if err := json.NewDecoder(r.Body).Decode(&classification); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, allergen := range classification.Allergens {
+			if !slices.Contains(knownAllergens, allergen) {
+				http.Error(w, "Unknown allergen "+allergen+", expected one of "+strings.Join(knownAllergens, ", "), http.StatusBadRequest)
+				return
+			}
+		}
+		for _, flag := range classification.DietFlags {
+			if !slices.Contains(knownDietFlags, flag) {
+				http.Error(w, "Unknown diet flag "+flag+", expected one of "+strings.Join(knownDietFlags, ", "), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := setIngredientClassification(id, classification); err != nil {
+			log.Printf("Failed to classify ingredient: %v", err)
+			http.Error(w, "Failed to update ingredient", http.StatusInternalServerError)
+			return
+		}
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	classification, err := getIngredientClassification(id)
+	if err != nil {
+		http.Error(w, "Failed to get ingredient", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(classification)
+}
+
+// Database helper functions
+func getIngredientClassification(ingredientID int) (*IngredientClassification, error) {
+	classification := &IngredientClassification{Allergens: []string{}, DietFlags: []string{}}
+
+	rows, err := db.Query("SELECT allergen FROM ingredient_allergens WHERE ingredient_id = ? ORDER BY allergen", ingredientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var allergen string
+		if err := rows.Scan(&allergen); err != nil {
+			return nil, err
+		}
+		classification.Allergens = append(classification.Allergens, allergen)
+	}
+
+	rows, err = db.Query("SELECT flag FROM ingredient_diet_flags WHERE ingredient_id = ? ORDER BY flag", ingredientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var flag string
+		if err := rows.Scan(&flag); err != nil {
+			return nil, err
+		}
+		classification.DietFlags = append(classification.DietFlags, flag)
+	}
+
+	return classification, nil
+}
+
+func setIngredientClassification(ingredientID int, classification IngredientClassification) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM ingredient_allergens WHERE ingredient_id = ?", ingredientID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM ingredient_diet_flags WHERE ingredient_id = ?", ingredientID); err != nil {
+		return err
+	}
+	for _, allergen := range classification.Allergens {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO ingredient_allergens (ingredient_id, allergen) VALUES (?, ?)", ingredientID, allergen); err != nil {
+			return err
+		}
+	}
+	for _, flag := range classification.DietFlags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO ingredient_diet_flags (ingredient_id, flag) VALUES (?, ?)", ingredientID, flag); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// classifyRecipe fills in the allergens and diets of a recipe from its
+// ingredients, and warns about tags that claim a diet the ingredients break.
+func classifyRecipe(recipe *Recipe) error {
+	recipe.Allergens = []string{}
+	recipe.Diets = []string{}
+	recipe.DietWarnings = nil
+
+	// Ingredient names per allergen and per diet flag found in the recipe
+	allergens := map[string][]string{}
+	flags := map[string][]string{}
+	for _, ing := range recipe.Ingredients {
+		classification, err := getIngredientClassification(ing.ID)
+		if err != nil {
+			return err
+		}
+		for _, allergen := range classification.Allergens {
+			allergens[allergen] = append(allergens[allergen], ing.Name)
+		}
+		for _, flag := range classification.DietFlags {
+			flags[flag] = append(flags[flag], ing.Name)
+		}
+	}
+
+	for _, allergen := range knownAllergens {
+		if _, ok := allergens[allergen]; ok {
+			recipe.Allergens = append(recipe.Allergens, allergen)
+		}
+	}
+
+	for _, d := range diets {
+		var offending []string
+		for _, flag := range d.ExcludedFlags {
+			offending = append(offending, flags[flag]...)
+		}
+		for _, allergen := range d.ExcludedAllergens {
+			offending = append(offending, allergens[allergen]...)
+		}
+		slices.Sort(offending)
+		offending = slices.Compact(offending)
+
+		if len(offending) == 0 {
+			recipe.Diets = append(recipe.Diets, d.Name)
+			continue
+		}
+
+		for _, tag := range recipe.Tags {
+			if strings.EqualFold(tag.Name, d.Name) {
+				recipe.DietWarnings = append(recipe.DietWarnings,
+					fmt.Sprintf("Tagged %s but contains %s", tag.Name, strings.Join(offending, ", ")))
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasAnyAllergen reports whether the recipe contains one of the allergens.
+func hasAnyAllergen(recipe Recipe, allergens []string) bool {
+	for _, allergen := range allergens {
+		if slices.Contains(recipe.Allergens, allergen) {
+			return true
+		}
+	}
+	return false
+}
+
+// seedAllergens annotates the sample ingredients that exist in the database,
+// matched by name.
+func seedAllergens() {
+	fmt.Println("Seeding allergen data...")
+
+	classifications := map[string]IngredientClassification{
+		"Spaghetti":         {Allergens: []string{"gluten"}},
+		"Eggs":              {Allergens: []string{"egg"}, DietFlags: []string{"animal"}},
+		"Pancetta":          {DietFlags: []string{"meat"}},
+		"Parmesan Cheese":   {Allergens: []string{"dairy"}, DietFlags: []string{"animal"}},
+		"Chicken Breast":    {DietFlags: []string{"meat"}},
+		"Breadcrumbs":       {Allergens: []string{"gluten"}},
+		"Mozzarella Cheese": {Allergens: []string{"dairy"}, DietFlags: []string{"animal"}},
+		"Penne Pasta":       {Allergens: []string{"gluten"}},
+		"Butter":            {Allergens: []string{"dairy"}, DietFlags: []string{"animal"}},
+		"Flour":             {Allergens: []string{"gluten"}},
+		"Salmon Fillet":     {Allergens: []string{"fish"}, DietFlags: []string{"fish"}},
+	}
+
+	for name, classification := range classifications {
+		var id int
+		err := db.QueryRow("SELECT id FROM ingredients WHERE name = ?", name).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err == nil {
+			err = setIngredientClassification(id, classification)
+		}
+		if err != nil {
+			log.Printf("Failed to insert allergens for %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Version of the cookbook archive format, increased whenever a change would
+// make older versions of the application misread an archive.
+const cookbookArchiveVersion = 1
+
+// cookbookArchiveFile is the name of the JSON document inside a zipped
+// archive, next to an images/ directory.
+const cookbookArchiveFile = "cookbook.json"
+
+var (
+	// Directory imported recipe images are written to
+	mediaDir = "./media"
+
+	// Largest cookbook archive accepted for import, in megabytes
+	maxImportMB int64 = 20
+
+	errUnsupportedArchive = errors.New("unsupported cookbook archive")
+)
+
+// Archive structure. IDs are the ones of the exporting database and only
+// serve to link recipes to ingredients and tags within the archive.
+type CookbookArchive struct {
+	Version     int                 `json:"version"`
+	ExportedAt  string              `json:"exported_at"`
+	Ingredients []ArchiveIngredient `json:"ingredients"`
+	Tags        []Tag               `json:"tags"`
+	Recipes     []ArchiveRecipe     `json:"recipes"`
+}
+
+type ArchiveIngredient struct {
+	ID        int                  `json:"id"`
+	Name      string               `json:"name"`
+	Nutrition *ingredientNutrition `json:"nutrition,omitempty"`
+	Allergens []string             `json:"allergens,omitempty"`
+	DietFlags []string             `json:"diet_flags,omitempty"`
+	Price     *IngredientPrice     `json:"price,omitempty"`
+}
+
+type ArchiveRecipe struct {
+	ID          int                       `json:"id"`
+	Title       string                    `json:"title"`
+	TimeMinutes int                       `json:"time_minutes"`
+	Price       string                    `json:"price"`
+	Link        string                    `json:"link"`
+	Description string                    `json:"description"`
+	Servings    int                       `json:"servings"`
+	Image       string                    `json:"image,omitempty"`
+	ImageFile   string                    `json:"image_file,omitempty"`
+	Ingredients []ArchiveRecipeIngredient `json:"ingredients"`
+	TagIDs      []int                     `json:"tag_ids"`
+}
+
+type ArchiveRecipeIngredient struct {
+	IngredientID int    `json:"ingredient_id"`
+	Amount       string `json:"amount"`
+	Unit         string `json:"unit"`
+}
+
+// CookbookImportReport describes what importing an archive changed.
+type CookbookImportReport struct {
+	Created            []ArchiveRecipeResult `json:"created"`
+	Duplicates         []ArchiveRecipeResult `json:"duplicates"`
+	IngredientsCreated int                   `json:"ingredients_created"`
+	IngredientsMatched int                   `json:"ingredients_matched"`
+	TagsCreated        int                   `json:"tags_created"`
+	TagsMatched        int                   `json:"tags_matched"`
+	Images             int                   `json:"images"`
+}
+
+// ArchiveRecipeResult links a recipe of the archive to the local recipe it was
+// imported as, or to the existing recipe with the same title.
+type ArchiveRecipeResult struct {
+	ArchiveID int    `json:"archive_id"`
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+}
+
+// Handler functions
+func cookbookExportHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: GET /api/cookbook/export/")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+
+	archive, err := exportCookbook()
+	if err != nil {
+		log.Printf("Failed to export cookbook: %v", err)
+		http.Error(w, "Failed to export cookbook", http.StatusInternalServerError)
+		return
+	}
+
+	name := "cookbook-" + time.Now().Format("2006-01-02")
+	if r.URL.Query().Get("format") == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.zip"`)
+		if err := writeCookbookZip(w, archive); err != nil {
+			log.Printf("Failed to write cookbook zip: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+	json.NewEncoder(w).Encode(archive)
+}
+
+func cookbookImportHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: POST /api/cookbook/import/")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportMB<<20))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("The archive is larger than %d MB", maxImportMB), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	report, err := importCookbookData(data)
+	if err != nil {
+		if errors.Is(err, errUnsupportedArchive) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			log.Printf("Failed to import cookbook: %v", err)
+			http.Error(w, "Failed to import cookbook", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// exportCookbook reads every recipe, ingredient and tag into an archive.
+func exportCookbook() (*CookbookArchive, error) {
+	archive := &CookbookArchive{
+		Version:     cookbookArchiveVersion,
+		ExportedAt:  time.Now().UTC().Format(time.RFC3339),
+		Ingredients: []ArchiveIngredient{},
+		Tags:        []Tag{},
+		Recipes:     []ArchiveRecipe{},
+	}
+
+	rows, err := db.Query("SELECT id, name FROM ingredients ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var ing ArchiveIngredient
+		if err := rows.Scan(&ing.ID, &ing.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		archive.Ingredients = append(archive.Ingredients, ing)
+	}
+	rows.Close()
+
+	for i := range archive.Ingredients {
+		ing := &archive.Ingredients[i]
+
+		ing.Nutrition, err = getIngredientNutrition(ing.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		classification, err := getIngredientClassification(ing.ID)
+		if err != nil {
+			return nil, err
+		}
+		ing.Allergens, ing.DietFlags = classification.Allergens, classification.DietFlags
+
+		ing.Price, err = getIngredientPrice(ing.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	rows, err = db.Query("SELECT id, name FROM tags ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		archive.Tags = append(archive.Tags, tag)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT id, title, time_minutes, price, COALESCE(link, ''), COALESCE(description, ''),
+			COALESCE(servings, 0), COALESCE(image, '')
+		FROM recipes WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var recipe ArchiveRecipe
+		if err := rows.Scan(&recipe.ID, &recipe.Title, &recipe.TimeMinutes, &recipe.Price, &recipe.Link,
+			&recipe.Description, &recipe.Servings, &recipe.Image); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		archive.Recipes = append(archive.Recipes, recipe)
+	}
+	rows.Close()
+
+	for i := range archive.Recipes {
+		recipe := &archive.Recipes[i]
+
+		ingredients, err := getIngredientsForRecipe(recipe.ID)
+		if err != nil {
+			return nil, err
+		}
+		recipe.Ingredients = []ArchiveRecipeIngredient{}
+		for _, ing := range ingredients {
+			recipe.Ingredients = append(recipe.Ingredients, ArchiveRecipeIngredient{ing.ID, ing.Amount, ing.Unit})
+		}
+
+		tags, err := getTagsForRecipe(recipe.ID)
+		if err != nil {
+			return nil, err
+		}
+		recipe.TagIDs = []int{}
+		for _, tag := range tags {
+			recipe.TagIDs = append(recipe.TagIDs, tag.ID)
+		}
+	}
+
+	return archive, nil
+}
+
+// writeCookbookZip writes the archive as cookbook.json together with the
+// recipe images that are stored as local files.
+func writeCookbookZip(w io.Writer, archive *CookbookArchive) error {
+	zw := zip.NewWriter(w)
+
+	for i := range archive.Recipes {
+		recipe := &archive.Recipes[i]
+		if recipe.Image == "" || strings.Contains(recipe.Image, "://") {
+			continue
+		}
+
+		image, err := os.ReadFile(recipe.Image)
+		if err != nil {
+			log.Printf("Skipping image of recipe %d: %v", recipe.ID, err)
+			continue
+		}
+
+		recipe.ImageFile = path.Join("images", fmt.Sprintf("%d-%s", recipe.ID, filepath.Base(recipe.Image)))
+		f, err := zw.Create(recipe.ImageFile)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(image); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create(cookbookArchiveFile)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(archive); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// importCookbookData imports an archive given as JSON or as a zip file.
+func importCookbookData(data []byte) (*CookbookImportReport, error) {
+	if !bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		var archive CookbookArchive
+		if err := json.Unmarshal(data, &archive); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUnsupportedArchive, err)
+		}
+		return importCookbook(&archive, nil)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnsupportedArchive, err)
+	}
+
+	var archive *CookbookArchive
+	images := map[string]*zip.File{}
+	for _, f := range zr.File {
+		if f.Name == cookbookArchiveFile {
+			archive = &CookbookArchive{}
+			if err := readZipJSON(f, archive); err != nil {
+				return nil, fmt.Errorf("%w: %v", errUnsupportedArchive, err)
+			}
+		} else if strings.HasPrefix(f.Name, "images/") {
+			images[f.Name] = f
+		}
+	}
+	if archive == nil {
+		return nil, fmt.Errorf("%w: %s is missing", errUnsupportedArchive, cookbookArchiveFile)
+	}
+
+	return importCookbook(archive, images)
+}
+
+func readZipJSON(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	// The entry may unpack to far more than the upload, so it gets the same limit
+	return json.NewDecoder(io.LimitReader(rc, maxImportMB<<20)).Decode(v)
+}
+
+// importCookbook adds the recipes of an archive that don't exist yet, matched
+// by title, remapping ingredient and tag IDs onto the local ones. Ingredients
+// and tags are matched by name and created if missing. images holds the image
+// files of a zipped archive by name.
+func importCookbook(archive *CookbookArchive, images map[string]*zip.File) (*CookbookImportReport, error) {
+	if archive.Version < 1 || archive.Version > cookbookArchiveVersion {
+		return nil, fmt.Errorf("%w: version %d, expected %d", errUnsupportedArchive, archive.Version, cookbookArchiveVersion)
+	}
+
+	report := &CookbookImportReport{
+		Created:    []ArchiveRecipeResult{},
+		Duplicates: []ArchiveRecipeResult{},
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ingredientIDs := map[int]int{}
+	for _, ing := range archive.Ingredients {
+		var id int
+		err := tx.QueryRow("SELECT id FROM ingredients WHERE name = ? COLLATE NOCASE", ing.Name).Scan(&id)
+		if err == nil {
+			report.IngredientsMatched++
+		} else if err == sql.ErrNoRows {
+			id, err = findOrCreateIngredient(tx, ing.Name)
+			if err != nil {
+				return nil, err
+			}
+			report.IngredientsCreated++
+		} else {
+			return nil, err
+		}
+		ingredientIDs[ing.ID] = id
+
+		// Ingredient data only fills gaps, local values are kept
+		if err := importIngredientData(tx, id, ing); err != nil {
+			return nil, err
+		}
+	}
+
+	tagIDs := map[int]int{}
+	for _, tag := range archive.Tags {
+		var id int
+		err := tx.QueryRow("SELECT id FROM tags WHERE name = ? COLLATE NOCASE", tag.Name).Scan(&id)
+		if err == nil {
+			report.TagsMatched++
+		} else if err == sql.ErrNoRows {
+			id, err = findOrCreateTag(tx, tag.Name)
+			if err != nil {
+				return nil, err
+			}
+			report.TagsCreated++
+		} else {
+			return nil, err
+		}
+		tagIDs[tag.ID] = id
+	}
+
+	// Extracted images are removed again unless the import is committed
+	var written []string
+	committed := false
+	defer func() {
+		if !committed {
+			for _, file := range written {
+				os.Remove(file)
+			}
+		}
+	}()
+
+	for _, archived := range archive.Recipes {
+		var existingID int
+		err := tx.QueryRow("SELECT id FROM recipes WHERE title = ? COLLATE NOCASE AND deleted_at IS NULL", archived.Title).Scan(&existingID)
+		if err == nil {
+			report.Duplicates = append(report.Duplicates, ArchiveRecipeResult{archived.ID, existingID, archived.Title})
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		recipe := &Recipe{
+			Title:       archived.Title,
+			TimeMinutes: archived.TimeMinutes,
+			Price:       archived.Price,
+			Link:        archived.Link,
+			Description: archived.Description,
+			Servings:    archived.Servings,
+		}
+		for _, ing := range archived.Ingredients {
+			id, ok := ingredientIDs[ing.IngredientID]
+			if !ok {
+				return nil, fmt.Errorf("%w: recipe %q uses unknown ingredient %d", errUnsupportedArchive, archived.Title, ing.IngredientID)
+			}
+			recipe.Ingredients = append(recipe.Ingredients, Ingredient{ID: id, Amount: ing.Amount, Unit: ing.Unit})
+		}
+		for _, archivedTagID := range archived.TagIDs {
+			id, ok := tagIDs[archivedTagID]
+			if !ok {
+				return nil, fmt.Errorf("%w: recipe %q uses unknown tag %d", errUnsupportedArchive, archived.Title, archivedTagID)
+			}
+			recipe.Tags = append(recipe.Tags, Tag{ID: id})
+		}
+
+		recipeID, err := insertRecipe(tx, recipe)
+		if err != nil {
+			return nil, err
+		}
+		report.Created = append(report.Created, ArchiveRecipeResult{archived.ID, recipeID, archived.Title})
+
+		// Remote image URLs are kept as they are, bundled images are extracted
+		image := archived.Image
+		if f, ok := images[archived.ImageFile]; ok && archived.ImageFile != "" {
+			image, err = extractImage(f, recipeID)
+			if err != nil {
+				return nil, err
+			}
+			written = append(written, image)
+			report.Images++
+		} else if !strings.Contains(image, "://") {
+			image = ""
+		}
+		if image != "" {
+			if _, err := tx.Exec("UPDATE recipes SET image = ? WHERE id = ?", image, recipeID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+
+	return report, nil
+}
+
+func importIngredientData(tx *sql.Tx, ingredientID int, ing ArchiveIngredient) error {
+	if ing.Nutrition != nil {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO ingredient_nutrition (ingredient_id, kcal, protein, fat, carbs, grams_per_ml)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			ingredientID, ing.Nutrition.Kcal, ing.Nutrition.Protein, ing.Nutrition.Fat, ing.Nutrition.Carbs, ing.Nutrition.GramsPerML,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ing.Price != nil {
+		price, ok := parsePrice(ing.Price.Price)
+		quantity, err := parseAmount(ing.Price.Quantity)
+		if ok && err == nil && quantity > 0 {
+			_, err := tx.Exec(
+				"INSERT OR IGNORE INTO ingredient_prices (ingredient_id, price, quantity, unit) VALUES (?, ?, ?, ?)",
+				ingredientID, price, quantity, ing.Price.Unit,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Allergens and diet flags are only taken over for unclassified ingredients
+	var classified int
+	err := tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM ingredient_allergens WHERE ingredient_id = ?)
+			+ (SELECT COUNT(*) FROM ingredient_diet_flags WHERE ingredient_id = ?)`,
+		ingredientID, ingredientID,
+	).Scan(&classified)
+	if err != nil || classified > 0 {
+		return err
+	}
+	for _, allergen := range ing.Allergens {
+		if !slices.Contains(knownAllergens, allergen) {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO ingredient_allergens (ingredient_id, allergen) VALUES (?, ?)", ingredientID, allergen); err != nil {
+			return err
+		}
+	}
+	for _, flag := range ing.DietFlags {
+		if !slices.Contains(knownDietFlags, flag) {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO ingredient_diet_flags (ingredient_id, flag) VALUES (?, ?)", ingredientID, flag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractImage writes an image of a zipped archive to the media directory and
+// returns its path.
+func extractImage(f *zip.File, recipeID int) (string, error) {
+	if err := os.MkdirAll(mediaDir, 0o755); err != nil {
+		return "", err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	// Only the base name is used so entries can't point outside mediaDir
+	name := filepath.Join(mediaDir, fmt.Sprintf("%d-%s", recipeID, path.Base(f.Name)))
+	out, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, io.LimitReader(rc, 20<<20)); err != nil {
+		out.Close()
+		os.Remove(name)
+		return "", err
+	}
+	return name, out.Close()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// Shortest password accepted when signing up
+const minPasswordLength = 8
+
+// authForm is the login and signup form.
+type authForm struct {
+	Name  string
+	Email string
+	Next  string
+
+	// Error is shown above the form, Errors next to the fields
+	Error  string
+	Errors map[string]string
+}
+
+// Handler functions
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /login/")
+
+	form := &authForm{Next: safeRedirect(r.FormValue("next"))}
+	switch r.Method {
+	case http.MethodGet:
+		if currentUser(r) != nil {
+			http.Redirect(w, r, form.Next, http.StatusSeeOther)
+			return
+		}
+		renderPage(w, r, "login.html", form)
+
+	case http.MethodPost:
+		if !validCSRF(r) {
+			http.Error(w, "Invalid or missing CSRF token, reload the form and try again", http.StatusForbidden)
+			return
+		}
+
+		form.Email = strings.TrimSpace(r.PostFormValue("email"))
+		userID, err := authenticate(form.Email, r.PostFormValue("password"))
+		if err == errNotAuthenticated {
+			form.Error = "The email address or password is not correct."
+			renderPageStatus(w, r, http.StatusUnprocessableEntity, "login.html", form)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+
+		if err := startSession(w, r, userID); err != nil {
+			log.Printf("Failed to create session: %v", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, form.Next, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func signupHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /signup/")
+
+	form := &authForm{Next: safeRedirect(r.FormValue("next"))}
+	switch r.Method {
+	case http.MethodGet:
+		renderPage(w, r, "signup.html", form)
+
+	case http.MethodPost:
+		if !validCSRF(r) {
+			http.Error(w, "Invalid or missing CSRF token, reload the form and try again", http.StatusForbidden)
+			return
+		}
+
+		form.Name = strings.TrimSpace(r.PostFormValue("name"))
+		form.Email = strings.TrimSpace(r.PostFormValue("email"))
+		password := r.PostFormValue("password")
+		form.validate(password, r.PostFormValue("password_confirm"))
+		if len(form.Errors) > 0 {
+			renderPageStatus(w, r, http.StatusUnprocessableEntity, "signup.html", form)
+			return
+		}
+
+		hash, err := hashPassword(password)
+		if err != nil {
+			http.Error(w, "Failed to create account", http.StatusInternalServerError)
+			return
+		}
+		result, err := db.Exec("INSERT INTO users (email, password, name) VALUES (?, ?, ?)", form.Email, hash, form.Name)
+		if err != nil {
+			log.Printf("Failed to create user: %v", err)
+			http.Error(w, "Failed to create account", http.StatusInternalServerError)
+			return
+		}
+		userID, err := result.LastInsertId()
+		if err != nil {
+			http.Error(w, "Failed to create account", http.StatusInternalServerError)
+			return
+		}
+
+		if err := startSession(w, r, int(userID)); err != nil {
+			log.Printf("Failed to create session: %v", err)
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		setFlash(w, fmt.Sprintf("Welcome, %s! Your account is ready.", form.Name))
+		http.Redirect(w, r, form.Next, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: POST /logout/")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+
+	if err := endSession(w, r); err != nil {
+		log.Printf("Failed to end session: %v", err)
+		http.Error(w, "Failed to sign out", http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, "You are signed out.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// validate checks the signup form, recording problems in Errors.
+func (f *authForm) validate(password, confirm string) {
+	f.Errors = map[string]string{}
+
+	if f.Name == "" {
+		f.Errors["name"] = "Enter your name."
+	}
+
+	if address, err := mail.ParseAddress(f.Email); err != nil || address.Address != f.Email {
+		f.Errors["email"] = "Enter an email address, like name@example.com."
+	} else {
+		var exists bool
+		err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = ? COLLATE NOCASE)", f.Email).Scan(&exists)
+		if err != nil && err != sql.ErrNoRows {
+			f.Errors["email"] = "The email address could not be checked, try again."
+		} else if exists {
+			f.Errors["email"] = "There is already an account with this email address."
+		}
+	}
+
+	if len(password) < minPasswordLength {
+		f.Errors["password"] = fmt.Sprintf("Use at least %d characters for your password.", minPasswordLength)
+	} else if password != confirm {
+		f.Errors["password_confirm"] = "The passwords don't match."
+	}
+}
+
+// startSession signs the browser in as the user, ending any session it
+// already had so a session token set by someone else is never kept.
+func startSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	if err := endSession(w, r); err != nil {
+		return err
+	}
+	return createSession(w, r, userID)
+}
+
+// requirePageUser is requireUser for HTML pages, sending visitors who are
+// not signed in to the login form.
+func requirePageUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := currentUserID(r)
+	if err == errNotAuthenticated {
+		http.Redirect(w, r, "/login/?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return 0, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to authenticate user", http.StatusInternalServerError)
+		return 0, false
+	}
+	return userID, true
+}
+
+// safeRedirect returns next if it is a path on this site to go back to after
+// signing in, and the home page otherwise.
+func safeRedirect(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || next == "" || u.IsAbs() || u.Host != "" ||
+		!strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, "\\") {
+		return "/"
+	}
+	for _, page := range []string{"/login/", "/signup/", "/logout/"} {
+		if strings.HasPrefix(u.Path, page) {
+			return "/"
+		}
+	}
+	return next
+}
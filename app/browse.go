@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Number of font sizes in the tag cloud
+const tagCloudSizes = 5
+
+// TagCount is a tag with the number of recipes that have it. Weight is the
+// font size in the tag cloud, from 1 for the least used tags to
+// tagCloudSizes for the most used.
+type TagCount struct {
+	Tag
+	Count  int
+	Weight int
+}
+
+// Handler functions
+func tagPageHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: GET /tags/<id>/")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/tags/")
+	if len(segments) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid tag ID", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := getTagByID(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get tag", http.StatusInternalServerError)
+		return
+	}
+
+	recipes, err := getRecipesByTag(id)
+	if err != nil {
+		http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Tag     *Tag
+		Recipes []RecipeSimple
+	}{
+		Tag:     tag,
+		Recipes: recipes,
+	}
+
+	renderPage(w, r, "tag.html", data)
+}
+
+func ingredientPageHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: GET /ingredients/<id>/")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/ingredients/")
+	if len(segments) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid ingredient ID", http.StatusBadRequest)
+		return
+	}
+
+	ingredient, err := getIngredientByID(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get ingredient", http.StatusInternalServerError)
+		return
+	}
+
+	recipes, err := getRecipesByIngredient(id)
+	if err != nil {
+		http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Ingredient *Ingredient
+		Recipes    []RecipeSimple
+	}{
+		Ingredient: ingredient,
+		Recipes:    recipes,
+	}
+
+	renderPage(w, r, "ingredient.html", data)
+}
+
+// Database helper functions
+func getTagByID(id int) (*Tag, error) {
+	var tag Tag
+	err := db.QueryRow("SELECT id, name FROM tags WHERE id = ?", id).Scan(&tag.ID, &tag.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func getIngredientByID(id int) (*Ingredient, error) {
+	var ing Ingredient
+	err := db.QueryRow("SELECT id, name FROM ingredients WHERE id = ?", id).Scan(&ing.ID, &ing.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &ing, nil
+}
+
+func getRecipesByTag(tagID int) ([]RecipeSimple, error) {
+	return queryRecipesSimple(`
+		SELECT id, title, time_minutes, price, link
+		FROM recipes
+		WHERE id IN (SELECT recipe_id FROM recipe_tags WHERE tag_id = ?) AND deleted_at IS NULL
+		ORDER BY title COLLATE NOCASE`, tagID)
+}
+
+func getRecipesByIngredient(ingredientID int) ([]RecipeSimple, error) {
+	return queryRecipesSimple(`
+		SELECT id, title, time_minutes, price, link
+		FROM recipes
+		WHERE id IN (SELECT recipe_id FROM recipe_ingredients WHERE ingredient_id = ?) AND deleted_at IS NULL
+		ORDER BY title COLLATE NOCASE`, ingredientID)
+}
+
+// getTagCloud returns the tags used by at least one recipe, by name, with
+// their weight spread evenly between the least and most used tag.
+func getTagCloud() ([]TagCount, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.name, COUNT(DISTINCT rt.recipe_id)
+		FROM tags t
+		JOIN recipe_tags rt ON t.id = rt.tag_id
+		JOIN recipes r ON r.id = rt.recipe_id AND r.deleted_at IS NULL
+		GROUP BY t.id
+		ORDER BY t.name COLLATE NOCASE`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []TagCount
+	least, most := 0, 0
+	for rows.Next() {
+		var tag TagCount
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Count); err != nil {
+			return nil, err
+		}
+		if len(tags) == 0 || tag.Count < least {
+			least = tag.Count
+		}
+		most = max(most, tag.Count)
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range tags {
+		tags[i].Weight = 1
+		if most > least {
+			tags[i].Weight += (tags[i].Count - least) * (tagCloudSizes - 1) / (most - least)
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runCommand runs a command line subcommand against the database.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "import-recipe":
+		return importRecipeCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	case "export-csv":
+		return exportCSVCommand(args[1:])
+	case "import-csv":
+		return importCSVCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q, available commands: import-recipe, export, import, export-csv, import-csv", args[0])
+	}
+}
+
+// importRecipeCommand imports schema.org Recipe JSON-LD from saved HTML pages
+// or JSON-LD files.
+func importRecipeCommand(files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("usage: import-recipe FILE...")
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		imported, err := importJSONLDRecipe(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		fmt.Printf("%s: imported recipe %d %q with %d ingredients\n",
+			file, imported.Recipe.ID, imported.Recipe.Title, len(imported.Recipe.Ingredients))
+		if len(imported.UnparsedLines) > 0 {
+			fmt.Printf("  could not parse:\n    %s\n", strings.Join(imported.UnparsedLines, "\n    "))
+		}
+	}
+
+	return nil
+}
+
+// exportCommand writes the whole cookbook to an archive file. A .zip file
+// also contains the recipe images.
+func exportCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: export FILE.json|FILE.zip")
+	}
+
+	archive, err := exportCookbook()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(strings.ToLower(args[0]), ".zip") {
+		err = writeCookbookZip(f, archive)
+	} else {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(archive)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d recipes, %d ingredients and %d tags to %s\n",
+		len(archive.Recipes), len(archive.Ingredients), len(archive.Tags), args[0])
+	return nil
+}
+
+// importCommand imports a cookbook archive written by export.
+func importCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: import FILE.json|FILE.zip")
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	report, err := importCookbookData(data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d recipes (%d ingredients created, %d matched; %d tags created, %d matched; %d images)\n",
+		len(report.Created), report.IngredientsCreated, report.IngredientsMatched,
+		report.TagsCreated, report.TagsMatched, report.Images)
+	for _, duplicate := range report.Duplicates {
+		fmt.Printf("  skipped %q, it already exists as recipe %d\n", duplicate.Title, duplicate.ID)
+	}
+	return nil
+}
+
+// exportCSVCommand writes the recipes or the ingredients to a CSV file.
+func exportCSVCommand(args []string) error {
+	if len(args) != 2 || (args[0] != "recipes" && args[0] != "ingredients") {
+		return fmt.Errorf("usage: export-csv recipes|ingredients FILE.csv")
+	}
+
+	f, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "recipes" {
+		err = writeRecipesCSV(f)
+	} else {
+		err = writeIngredientsCSV(f)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %s to %s\n", args[0], args[1])
+	return nil
+}
+
+// importCSVCommand imports recipes or ingredients from a CSV file. With
+// -dry-run it only reports what the import would do.
+func importCSVCommand(args []string) error {
+	flags := flag.NewFlagSet("import-csv", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without changing the database")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+	if len(args) != 2 || (args[0] != "recipes" && args[0] != "ingredients") {
+		return fmt.Errorf("usage: import-csv [-dry-run] recipes|ingredients FILE.csv")
+	}
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	verb := "Imported"
+	if *dryRun {
+		verb = "Would import"
+	}
+
+	var invalid []CSVInvalidRow
+	if args[0] == "recipes" {
+		report, err := importRecipesCSV(f, *dryRun)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %d recipes (%d ingredients created, %d matched)\n",
+			verb, len(report.Created), len(report.IngredientsCreated), len(report.IngredientsMatched))
+		for _, row := range report.Created {
+			fmt.Printf("  line %d: %s\n", row.Line, row.Name)
+		}
+		if len(report.IngredientsCreated) > 0 {
+			fmt.Printf("  new ingredients: %s\n", strings.Join(report.IngredientsCreated, ", "))
+		}
+		for _, row := range report.Duplicates {
+			fmt.Printf("  line %d: skipped %q, it already exists as recipe %d\n", row.Line, row.Name, row.ID)
+		}
+		invalid = report.Invalid
+	} else {
+		report, err := importIngredientsCSV(f, *dryRun)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %d new and %d matched ingredients\n", verb, len(report.Created), len(report.Matched))
+		for _, row := range report.Created {
+			fmt.Printf("  line %d: new %s\n", row.Line, row.Name)
+		}
+		for _, row := range report.Matched {
+			fmt.Printf("  line %d: matched %s (ingredient %d)\n", row.Line, row.Name, row.ID)
+		}
+		invalid = report.Invalid
+	}
+
+	for _, row := range invalid {
+		if row.Name != "" {
+			fmt.Printf("  line %d: invalid %s: %s\n", row.Line, row.Name, row.Error)
+		} else {
+			fmt.Printf("  line %d: invalid: %s\n", row.Line, row.Error)
+		}
+	}
+	return nil
+}
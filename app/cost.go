@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// IngredientPrice is what a quantity of an ingredient costs, e.g. 1.80 for
+// 500 g of spaghetti or 0.35 for 1 large egg.
+type IngredientPrice struct {
+	Price    string `json:"price"`
+	Quantity string `json:"quantity"`
+	Unit     string `json:"unit"`
+}
+
+// RecipeCost compares the hand-entered recipe price with the cost computed
+// from the ingredient prices. Ingredients without a usable price are listed in
+// Unpriced and left out of Computed.
+type RecipeCost struct {
+	Stored     string                  `json:"stored"`
+	Computed   string                  `json:"computed"`
+	Difference string                  `json:"difference,omitempty"`
+	Unpriced   []UnconvertedIngredient `json:"unpriced"`
+}
+
+// Handler functions
+func ingredientPriceHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/ingredients/<id>/price/")
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid ingredient ID", http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	err = db.QueryRow("SELECT name FROM ingredients WHERE id = ?", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get ingredient", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// Nothing to change, the price is returned below
+
+	case http.MethodPut:
+		if _, ok := requireUser(w, r); !ok {
+			return
+		}
+		var priceReq IngredientPrice
+		if err := json.NewDecoder(r.Body).Decode(&priceReq); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		price, ok := parsePrice(priceReq.Price)
+		if !ok || price < 0 {
+			http.Error(w, "Invalid price", http.StatusBadRequest)
+			return
+		}
+		if priceReq.Quantity == "" {
+			priceReq.Quantity = "1"
+		}
+		quantity, err := parseAmount(priceReq.Quantity)
+		if err != nil || quantity <= 0 {
+			http.Error(w, "Invalid quantity", http.StatusBadRequest)
+			return
+		}
+		if priceReq.Unit == "" {
+			http.Error(w, "Unit is required", http.StatusBadRequest)
+			return
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO ingredient_prices (ingredient_id, price, quantity, unit) VALUES (?, ?, ?, ?)
+			ON CONFLICT (ingredient_id) DO UPDATE SET price = excluded.price, quantity = excluded.quantity, unit = excluded.unit`,
+			id, price, quantity, priceReq.Unit,
+		)
+		if err != nil {
+			log.Printf("Failed to set ingredient price: %v", err)
+			http.Error(w, "Failed to update ingredient", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		if _, ok := requireUser(w, r); !ok {
+			return
+		}
+		if _, err := db.Exec("DELETE FROM ingredient_prices WHERE ingredient_id = ?", id); err != nil {
+			http.Error(w, "Failed to update ingredient", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	price, err := getIngredientPrice(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Ingredient has no price", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get ingredient price", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(price)
+}
+
+// Database helper functions
+func getIngredientPrice(ingredientID int) (*IngredientPrice, error) {
+	var price, quantity float64
+	var unit string
+	err := db.QueryRow("SELECT price, quantity, unit FROM ingredient_prices WHERE ingredient_id = ?", ingredientID).
+		Scan(&price, &quantity, &unit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IngredientPrice{
+		Price:    strconv.FormatFloat(price, 'f', 2, 64),
+		Quantity: strconv.FormatFloat(quantity, 'f', -1, 64),
+		Unit:     unit,
+	}, nil
+}
+
+// getCostForRecipe computes the cost of the recipe ingredients from their
+// unit prices and compares it with the stored price.
+func getCostForRecipe(ingredients []Ingredient, storedPrice string) (*RecipeCost, error) {
+	cost := &RecipeCost{Stored: storedPrice, Unpriced: []UnconvertedIngredient{}}
+
+	total := 0.0
+	for _, ing := range ingredients {
+		var price, quantity float64
+		var unit string
+		err := db.QueryRow("SELECT price, quantity, unit FROM ingredient_prices WHERE ingredient_id = ?", ing.ID).
+			Scan(&price, &quantity, &unit)
+		if err == sql.ErrNoRows {
+			cost.Unpriced = append(cost.Unpriced, UnconvertedIngredient{ing, "no price"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := parseAmount(ing.Amount)
+		if err != nil {
+			cost.Unpriced = append(cost.Unpriced, UnconvertedIngredient{ing, err.Error()})
+			continue
+		}
+
+		density, err := getIngredientDensity(ing.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Express the recipe amount in the unit the price is given for
+		converted, err := convertAmount(amount, ing.Unit, unit, density)
+		if err != nil {
+			cost.Unpriced = append(cost.Unpriced, UnconvertedIngredient{ing, err.Error()})
+			continue
+		}
+
+		total += converted / quantity * price
+	}
+
+	cost.Computed = fmt.Sprintf("%.2f", total)
+	if stored, ok := parsePrice(storedPrice); ok {
+		cost.Difference = fmt.Sprintf("%+.2f", total-stored)
+	}
+
+	return cost, nil
+}
+
+// seedPrices fills in unit prices for the sample ingredients that exist in
+// the database, matched by name.
+func seedPrices() {
+	fmt.Println("Seeding ingredient prices...")
+
+	prices := map[string]struct {
+		price    float64
+		quantity float64
+		unit     string
+	}{
+		"Spaghetti":         {1.80, 500, "g"},
+		"Eggs":              {0.35, 1, "large"},
+		"Pancetta":          {4.50, 200, "g"},
+		"Parmesan Cheese":   {5.00, 200, "g"},
+		"Black Pepper":      {3.00, 50, "g"},
+		"Salt":              {1.00, 1, "kg"},
+		"Chicken Breast":    {3.50, 1, "piece"},
+		"Breadcrumbs":       {2.00, 250, "g"},
+		"Mozzarella Cheese": {2.50, 125, "g"},
+		"Tomato Sauce":      {2.20, 500, "ml"},
+		"Olive Oil":         {8.00, 1, "l"},
+		"Garlic":            {0.25, 1, "clove"},
+		"Penne Pasta":       {1.80, 500, "g"},
+		"Bell Peppers":      {1.20, 1, "piece"},
+		"Zucchini":          {0.90, 1, "piece"},
+		"Cherry Tomatoes":   {3.00, 250, "g"},
+		"Basil":             {2.00, 20, "leaves"},
+		"Butter":            {2.50, 250, "g"},
+		"Flour":             {1.00, 1, "kg"},
+		"Salmon Fillet":     {4.00, 1, "fillet"},
+		"Lemon":             {0.50, 1, "piece"},
+		"Dill":              {1.50, 20, "g"},
+	}
+
+	for name, p := range prices {
+		_, err := db.Exec(`
+			INSERT INTO ingredient_prices (ingredient_id, price, quantity, unit)
+			SELECT id, ?, ?, ? FROM ingredients WHERE name = ?`,
+			p.price, p.quantity, p.unit, name,
+		)
+		if err != nil {
+			log.Printf("Failed to insert price for %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+// Forms are protected with a double-submit token: a random value kept in a
+// cookie that every form posts back in a hidden field. Other sites can make
+// the browser send the cookie but can't read it to fill in the field.
+const (
+	csrfCookie = "csrf_token"
+	csrfField  = "csrf_token"
+)
+
+// csrfToken returns the CSRF token of the visitor, setting a new one if
+// there is none yet. It must be called before the response body is written.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) >= 32 {
+		return cookie.Value
+	}
+
+	token := randomToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Later calls within the same request see the new token
+	r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	return token
+}
+
+// validCSRF reports whether the posted form carries the token of the cookie.
+func validCSRF(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostFormValue(csrfField))) == 1
+}
+
+// randomToken returns 32 random bytes encoded for use in URLs and cookies.
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,690 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Columns of the CSV files. Recipes have one row per recipe ingredient, the
+// recipe columns are repeated on every row. Lists within a cell, like tags or
+// allergens, are separated by semicolons.
+var (
+	recipeCSVColumns = []string{
+		"recipe_id", "title", "time_minutes", "price", "servings", "link", "description", "tags",
+		"ingredient", "amount", "unit",
+	}
+
+	ingredientCSVColumns = []string{
+		"id", "name", "kcal", "protein", "fat", "carbs", "grams_per_ml", "allergens", "diet_flags",
+		"price", "price_quantity", "price_unit",
+	}
+
+	errInvalidCSV = errors.New("invalid CSV")
+)
+
+// CSV import reports. In a dry run nothing is written and no IDs are given.
+type RecipeCSVReport struct {
+	DryRun             bool            `json:"dry_run"`
+	Created            []CSVRow        `json:"created"`
+	Duplicates         []CSVRow        `json:"duplicates"`
+	IngredientsCreated []string        `json:"ingredients_created"`
+	IngredientsMatched []string        `json:"ingredients_matched"`
+	Invalid            []CSVInvalidRow `json:"invalid"`
+}
+
+type IngredientCSVReport struct {
+	DryRun  bool            `json:"dry_run"`
+	Created []CSVRow        `json:"created"`
+	Matched []CSVRow        `json:"matched"`
+	Invalid []CSVInvalidRow `json:"invalid"`
+}
+
+// CSVRow is a recipe or ingredient of the file with the line it starts on.
+type CSVRow struct {
+	Line int    `json:"line"`
+	ID   int    `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type CSVInvalidRow struct {
+	Line  int    `json:"line"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// csvTable is a parsed CSV file whose cells are looked up by column name, so
+// spreadsheets may order the columns as they like and add their own.
+type csvTable struct {
+	columns map[string]int
+	rows    [][]string
+	lines   []int
+}
+
+// Handler functions
+func recipeCSVHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/recipes/csv/")
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="recipes.csv"`)
+		if err := writeRecipesCSV(w); err != nil {
+			log.Printf("Failed to export recipes: %v", err)
+		}
+
+	case http.MethodPost:
+		// A dry run changes nothing, so it is open to anyone
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		if !dryRun {
+			if _, ok := requireUser(w, r); !ok {
+				return
+			}
+		}
+		report, err := importRecipesCSV(io.LimitReader(r.Body, 10<<20), dryRun)
+		if err != nil {
+			csvImportError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func ingredientCSVHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/ingredients/csv/")
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="ingredients.csv"`)
+		if err := writeIngredientsCSV(w); err != nil {
+			log.Printf("Failed to export ingredients: %v", err)
+		}
+
+	case http.MethodPost:
+		// A dry run changes nothing, so it is open to anyone
+		dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		if !dryRun {
+			if _, ok := requireUser(w, r); !ok {
+				return
+			}
+		}
+		report, err := importIngredientsCSV(io.LimitReader(r.Body, 10<<20), dryRun)
+		if err != nil {
+			csvImportError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func csvImportError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidCSV) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Failed to import CSV: %v", err)
+	http.Error(w, "Failed to import CSV", http.StatusInternalServerError)
+}
+
+// writeRecipesCSV writes one row per recipe ingredient. Recipes without
+// ingredients get a single row with empty ingredient columns.
+func writeRecipesCSV(w io.Writer) error {
+	rows, err := db.Query(`
+		SELECT id, title, time_minutes, price, COALESCE(servings, 0), COALESCE(link, ''), COALESCE(description, '')
+		FROM recipes WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	var recipes []Recipe
+	for rows.Next() {
+		var recipe Recipe
+		if err := rows.Scan(&recipe.ID, &recipe.Title, &recipe.TimeMinutes, &recipe.Price, &recipe.Servings,
+			&recipe.Link, &recipe.Description); err != nil {
+			rows.Close()
+			return err
+		}
+		recipes = append(recipes, recipe)
+	}
+	rows.Close()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(recipeCSVColumns); err != nil {
+		return err
+	}
+
+	for _, recipe := range recipes {
+		ingredients, err := getIngredientsForRecipe(recipe.ID)
+		if err != nil {
+			return err
+		}
+		tags, err := getTagsForRecipe(recipe.ID)
+		if err != nil {
+			return err
+		}
+		var tagNames []string
+		for _, tag := range tags {
+			tagNames = append(tagNames, tag.Name)
+		}
+
+		servings := ""
+		if recipe.Servings > 0 {
+			servings = strconv.Itoa(recipe.Servings)
+		}
+		record := []string{
+			strconv.Itoa(recipe.ID), recipe.Title, strconv.Itoa(recipe.TimeMinutes), recipe.Price, servings,
+			recipe.Link, recipe.Description, strings.Join(tagNames, ";"),
+		}
+
+		if len(ingredients) == 0 {
+			ingredients = []Ingredient{{}}
+		}
+		for _, ing := range ingredients {
+			if err := cw.Write(append(slices.Clip(record), ing.Name, ing.Amount, ing.Unit)); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeIngredientsCSV(w io.Writer) error {
+	rows, err := db.Query("SELECT id, name FROM ingredients ORDER BY name COLLATE NOCASE")
+	if err != nil {
+		return err
+	}
+	var ingredients []Ingredient
+	for rows.Next() {
+		var ing Ingredient
+		if err := rows.Scan(&ing.ID, &ing.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		ingredients = append(ingredients, ing)
+	}
+	rows.Close()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(ingredientCSVColumns); err != nil {
+		return err
+	}
+
+	for _, ing := range ingredients {
+		record := []string{strconv.Itoa(ing.ID), ing.Name}
+
+		nutrition, err := getIngredientNutrition(ing.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if nutrition != nil {
+			record = append(record, formatCSVFloat(nutrition.Kcal), formatCSVFloat(nutrition.Protein),
+				formatCSVFloat(nutrition.Fat), formatCSVFloat(nutrition.Carbs), formatCSVFloat(nutrition.GramsPerML))
+		} else {
+			record = append(record, "", "", "", "", "")
+		}
+
+		classification, err := getIngredientClassification(ing.ID)
+		if err != nil {
+			return err
+		}
+		record = append(record, strings.Join(classification.Allergens, ";"), strings.Join(classification.DietFlags, ";"))
+
+		price, err := getIngredientPrice(ing.ID)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if price != nil {
+			record = append(record, price.Price, price.Quantity, price.Unit)
+		} else {
+			record = append(record, "", "", "")
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// importRecipesCSV creates the recipes of a CSV file written by
+// writeRecipesCSV or a spreadsheet with the same columns. Rows are grouped into
+// recipes by title, and recipes whose title already exists are skipped. A
+// recipe with an invalid row is not imported. In a dry run the import is
+// rolled back, so the report shows what would happen.
+func importRecipesCSV(r io.Reader, dryRun bool) (*RecipeCSVReport, error) {
+	table, err := readCSV(r, "title", "time_minutes")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RecipeCSVReport{
+		DryRun:             dryRun,
+		Created:            []CSVRow{},
+		Duplicates:         []CSVRow{},
+		IngredientsCreated: []string{},
+		IngredientsMatched: []string{},
+		Invalid:            []CSVInvalidRow{},
+	}
+
+	// Rows of each recipe in file order, by lower case title
+	var titles []string
+	groups := map[string][]int{}
+	for i, row := range table.rows {
+		title := table.get(row, "title")
+		if title == "" {
+			report.Invalid = append(report.Invalid, CSVInvalidRow{Line: table.lines[i], Error: "title is required"})
+			continue
+		}
+		key := strings.ToLower(title)
+		if _, ok := groups[key]; !ok {
+			titles = append(titles, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Ingredients already counted as created or matched, by lower case name
+	seenIngredients := map[string]bool{}
+
+	for _, key := range titles {
+		recipe, invalid := parseRecipeCSVRows(table, groups[key])
+		if len(invalid) > 0 {
+			report.Invalid = append(report.Invalid, invalid...)
+			continue
+		}
+		line := table.lines[groups[key][0]]
+
+		var existingID int
+		err := tx.QueryRow("SELECT id FROM recipes WHERE title = ? COLLATE NOCASE AND deleted_at IS NULL", recipe.Title).Scan(&existingID)
+		if err == nil {
+			report.Duplicates = append(report.Duplicates, CSVRow{line, existingID, recipe.Title})
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		for i := range recipe.Ingredients {
+			ing := &recipe.Ingredients[i]
+			name := strings.ToLower(ing.Name)
+			if !seenIngredients[name] {
+				seenIngredients[name] = true
+				err := tx.QueryRow("SELECT id FROM ingredients WHERE name = ? COLLATE NOCASE", ing.Name).Scan(&ing.ID)
+				if err == nil {
+					report.IngredientsMatched = append(report.IngredientsMatched, ing.Name)
+				} else if err == sql.ErrNoRows {
+					report.IngredientsCreated = append(report.IngredientsCreated, ing.Name)
+				} else {
+					return nil, err
+				}
+			}
+			if ing.ID == 0 {
+				if ing.ID, err = findOrCreateIngredient(tx, ing.Name); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		recipeID, err := insertRecipe(tx, recipe)
+		if err != nil {
+			return nil, err
+		}
+		if dryRun {
+			recipeID = 0
+		}
+		report.Created = append(report.Created, CSVRow{line, recipeID, recipe.Title})
+	}
+
+	slices.SortStableFunc(report.Invalid, func(a, b CSVInvalidRow) int { return a.Line - b.Line })
+
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+// parseRecipeCSVRows builds the recipe described by the given rows of a
+// recipe CSV file. The recipe columns are taken from the first row.
+func parseRecipeCSVRows(table *csvTable, rowIndexes []int) (*Recipe, []CSVInvalidRow) {
+	first := table.rows[rowIndexes[0]]
+	recipe := &Recipe{
+		Title:       table.get(first, "title"),
+		Price:       table.get(first, "price"),
+		Link:        table.get(first, "link"),
+		Description: table.get(first, "description"),
+	}
+
+	var invalid []CSVInvalidRow
+	fail := func(line int, format string, args ...any) {
+		invalid = append(invalid, CSVInvalidRow{line, recipe.Title, fmt.Sprintf(format, args...)})
+	}
+
+	line := table.lines[rowIndexes[0]]
+	minutes, err := strconv.Atoi(table.get(first, "time_minutes"))
+	if err != nil || minutes < 0 {
+		fail(line, "invalid time_minutes %q", table.get(first, "time_minutes"))
+	}
+	recipe.TimeMinutes = minutes
+
+	if servings := table.get(first, "servings"); servings != "" {
+		recipe.Servings, err = strconv.Atoi(servings)
+		if err != nil || recipe.Servings < 0 {
+			fail(line, "invalid servings %q", servings)
+		}
+	}
+	if recipe.Price != "" {
+		if price, ok := parsePrice(recipe.Price); !ok || price < 0 {
+			fail(line, "invalid price %q", recipe.Price)
+		}
+	}
+
+	for _, name := range splitCSVList(table.get(first, "tags")) {
+		recipe.Tags = append(recipe.Tags, Tag{Name: name})
+	}
+
+	for _, i := range rowIndexes {
+		row, line := table.rows[i], table.lines[i]
+		ing := Ingredient{
+			Name:   table.get(row, "ingredient"),
+			Amount: table.get(row, "amount"),
+			Unit:   table.get(row, "unit"),
+		}
+		if ing.Name == "" {
+			if ing.Amount != "" || ing.Unit != "" {
+				fail(line, "amount or unit given without ingredient")
+			}
+			continue
+		}
+		if ing.Amount != "" {
+			if _, err := parseAmount(ing.Amount); err != nil {
+				fail(line, "invalid amount %q for %s", ing.Amount, ing.Name)
+				continue
+			}
+		}
+		recipe.Ingredients = append(recipe.Ingredients, ing)
+	}
+
+	return recipe, invalid
+}
+
+// importIngredientsCSV creates the ingredients of a CSV file that don't exist
+// yet and updates the ones matched by name. Empty cells leave the existing
+// values unchanged. Invalid rows are skipped. In a dry run the import is
+// rolled back, so the report shows what would happen.
+func importIngredientsCSV(r io.Reader, dryRun bool) (*IngredientCSVReport, error) {
+	table, err := readCSV(r, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &IngredientCSVReport{
+		DryRun:  dryRun,
+		Created: []CSVRow{},
+		Matched: []CSVRow{},
+		Invalid: []CSVInvalidRow{},
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	seen := map[string]int{}
+	for i, row := range table.rows {
+		line, name := table.lines[i], table.get(row, "name")
+		if name == "" {
+			report.Invalid = append(report.Invalid, CSVInvalidRow{Line: line, Error: "name is required"})
+			continue
+		}
+		if first, ok := seen[strings.ToLower(name)]; ok {
+			report.Invalid = append(report.Invalid, CSVInvalidRow{line, name, fmt.Sprintf("duplicate of line %d", first)})
+			continue
+		}
+		seen[strings.ToLower(name)] = line
+
+		nutrition, err := validateIngredientCSVRow(table, row)
+		if err != nil {
+			report.Invalid = append(report.Invalid, CSVInvalidRow{line, name, err.Error()})
+			continue
+		}
+
+		var id int
+		err = tx.QueryRow("SELECT id FROM ingredients WHERE name = ? COLLATE NOCASE", name).Scan(&id)
+		if err == nil {
+			report.Matched = append(report.Matched, CSVRow{line, id, name})
+		} else if err == sql.ErrNoRows {
+			if id, err = findOrCreateIngredient(tx, name); err != nil {
+				return nil, err
+			}
+			createdID := id
+			if dryRun {
+				createdID = 0
+			}
+			report.Created = append(report.Created, CSVRow{line, createdID, name})
+		} else {
+			return nil, err
+		}
+
+		if err := updateIngredientFromCSV(tx, id, table, row, nutrition); err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+// validateIngredientCSVRow checks the cells of an ingredient row and returns
+// its nutrition, nil if the row has no nutrition cells.
+func validateIngredientCSVRow(table *csvTable, row []string) (*ingredientNutrition, error) {
+	nutritionColumns := []string{"kcal", "protein", "fat", "carbs"}
+	values := map[string]float64{"grams_per_ml": 1}
+	given := 0
+	for _, column := range append(nutritionColumns, "grams_per_ml") {
+		if value := table.get(row, column); value != "" {
+			given++
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil || !validAmount(n) || (column == "grams_per_ml" && n == 0) {
+				return nil, fmt.Errorf("invalid %s %q", column, value)
+			}
+			values[column] = n
+		}
+	}
+
+	var nutrition *ingredientNutrition
+	if given > 0 {
+		for _, column := range nutritionColumns {
+			if table.get(row, column) == "" {
+				return nil, fmt.Errorf("nutrition needs kcal, protein, fat and carbs, %s is missing", column)
+			}
+		}
+		nutrition = &ingredientNutrition{
+			NutritionFacts: NutritionFacts{values["kcal"], values["protein"], values["fat"], values["carbs"]},
+			GramsPerML:     values["grams_per_ml"],
+		}
+	}
+
+	for _, allergen := range splitCSVList(table.get(row, "allergens")) {
+		if !slices.Contains(knownAllergens, strings.ToLower(allergen)) {
+			return nil, fmt.Errorf("unknown allergen %s, expected one of %s", allergen, strings.Join(knownAllergens, ", "))
+		}
+	}
+	for _, flag := range splitCSVList(table.get(row, "diet_flags")) {
+		if !slices.Contains(knownDietFlags, strings.ToLower(flag)) {
+			return nil, fmt.Errorf("unknown diet flag %s, expected one of %s", flag, strings.Join(knownDietFlags, ", "))
+		}
+	}
+
+	if price := table.get(row, "price"); price != "" {
+		if p, ok := parsePrice(price); !ok || p < 0 {
+			return nil, fmt.Errorf("invalid price %q", price)
+		}
+		if quantity := table.get(row, "price_quantity"); quantity != "" {
+			if q, err := parseAmount(quantity); err != nil || q <= 0 {
+				return nil, fmt.Errorf("invalid price_quantity %q", quantity)
+			}
+		}
+		if table.get(row, "price_unit") == "" {
+			return nil, fmt.Errorf("price_unit is required with a price")
+		}
+	}
+
+	return nutrition, nil
+}
+
+// updateIngredientFromCSV stores the non-empty cells of a validated row and
+// the nutrition validateIngredientCSVRow parsed from it.
+func updateIngredientFromCSV(tx *sql.Tx, ingredientID int, table *csvTable, row []string, nutrition *ingredientNutrition) error {
+	if nutrition != nil {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO ingredient_nutrition (ingredient_id, kcal, protein, fat, carbs, grams_per_ml)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			ingredientID, nutrition.Kcal, nutrition.Protein, nutrition.Fat, nutrition.Carbs, nutrition.GramsPerML,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if allergens := splitCSVList(table.get(row, "allergens")); len(allergens) > 0 {
+		if _, err := tx.Exec("DELETE FROM ingredient_allergens WHERE ingredient_id = ?", ingredientID); err != nil {
+			return err
+		}
+		for _, allergen := range allergens {
+			_, err := tx.Exec("INSERT OR IGNORE INTO ingredient_allergens (ingredient_id, allergen) VALUES (?, ?)",
+				ingredientID, strings.ToLower(allergen))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if flags := splitCSVList(table.get(row, "diet_flags")); len(flags) > 0 {
+		if _, err := tx.Exec("DELETE FROM ingredient_diet_flags WHERE ingredient_id = ?", ingredientID); err != nil {
+			return err
+		}
+		for _, flag := range flags {
+			_, err := tx.Exec("INSERT OR IGNORE INTO ingredient_diet_flags (ingredient_id, flag) VALUES (?, ?)",
+				ingredientID, strings.ToLower(flag))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if priceCell := table.get(row, "price"); priceCell != "" {
+		price, _ := parsePrice(priceCell)
+		quantity := 1.0
+		if cell := table.get(row, "price_quantity"); cell != "" {
+			quantity, _ = parseAmount(cell)
+		}
+		_, err := tx.Exec(`
+			INSERT INTO ingredient_prices (ingredient_id, price, quantity, unit) VALUES (?, ?, ?, ?)
+			ON CONFLICT (ingredient_id) DO UPDATE SET price = excluded.price, quantity = excluded.quantity, unit = excluded.unit`,
+			ingredientID, price, quantity, table.get(row, "price_unit"),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readCSV reads a CSV file with a header row that has at least the required
+// columns. Blank rows are skipped.
+func readCSV(r io.Reader, required ...string) (*csvTable, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the file is empty", errInvalidCSV)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCSV, err)
+	}
+
+	table := &csvTable{columns: map[string]int{}}
+	for i, column := range header {
+		// Spreadsheet programs like to start UTF-8 files with a byte order mark
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		table.columns[column] = i
+	}
+	for _, column := range required {
+		if _, ok := table.columns[column]; !ok {
+			return nil, fmt.Errorf("%w: missing column %s", errInvalidCSV, column)
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidCSV, err)
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		table.rows = append(table.rows, record)
+		table.lines = append(table.lines, line)
+	}
+
+	return table, nil
+}
+
+// get returns the trimmed cell of the row in the given column, empty if the
+// file has no such column or the row is too short.
+func (t *csvTable) get(row []string, column string) string {
+	i, ok := t.columns[column]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
+
+func splitCSVList(cell string) []string {
+	var items []string
+	for _, item := range strings.Split(cell, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func formatCSVFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Users mark recipes as favorites and can sort their favorites into named
+// collections. A recipe added to a collection becomes a favorite, and a
+// recipe that is no longer a favorite leaves the user's collections.
+type Collection struct {
+	ID      int            `json:"id"`
+	UserID  int            `json:"user_id"`
+	Name    string         `json:"name"`
+	Recipes []RecipeSimple `json:"recipes"`
+}
+
+type CollectionRequest struct {
+	Name string `json:"name"`
+}
+
+type FavoriteRequest struct {
+	RecipeID int `json:"recipe_id"`
+}
+
+// Longest collection name accepted
+const maxCollectionNameLength = 100
+
+// Handler functions
+func favoritesHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /api/favorites/")
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/api/favorites/")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		favoritesListHandler(w, userID, http.StatusOK)
+
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		var req FavoriteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, err := getRecipeTitle(req.RecipeID); err != nil {
+			http.Error(w, "Recipe not found", http.StatusBadRequest)
+			return
+		}
+		if err := addFavorite(userID, req.RecipeID); err != nil {
+			log.Printf("Failed to add favorite: %v", err)
+			http.Error(w, "Failed to add favorite", http.StatusInternalServerError)
+			return
+		}
+		favoritesListHandler(w, userID, http.StatusCreated)
+
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		recipeID, err := strconv.Atoi(segments[0])
+		if err != nil {
+			http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+			return
+		}
+		err = removeFavorite(userID, recipeID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Favorite not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to remove favorite", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) > 1:
+		http.NotFound(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func favoritesListHandler(w http.ResponseWriter, userID int, status int) {
+	recipes, err := getFavoriteRecipes(userID)
+	if err != nil {
+		http.Error(w, "Failed to get favorites", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(recipes)
+}
+
+func collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /api/collections/")
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/api/collections/")
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			collections, err := getCollections(userID)
+			if err != nil {
+				http.Error(w, "Failed to get collections", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(collections)
+		case http.MethodPost:
+			collectionCreateHandler(w, r, userID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	collectionID, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid collection ID", http.StatusBadRequest)
+		return
+	}
+
+	collection, err := getCollection(collectionID)
+	if err == sql.ErrNoRows || (err == nil && collection.UserID != userID) {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get collection", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(collection)
+
+	case len(segments) == 1 && r.Method == http.MethodPatch:
+		var req CollectionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name, err := validCollectionName(userID, req.Name, collection.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := db.Exec("UPDATE collections SET name = ? WHERE id = ?", name, collection.ID); err != nil {
+			http.Error(w, "Failed to rename collection", http.StatusInternalServerError)
+			return
+		}
+		collection.Name = name
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(collection)
+
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		if err := deleteCollection(collection.ID); err != nil {
+			http.Error(w, "Failed to delete collection", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) == 2 && segments[1] == "recipes" && r.Method == http.MethodPost:
+		var req FavoriteRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, err := getRecipeTitle(req.RecipeID); err != nil {
+			http.Error(w, "Recipe not found", http.StatusBadRequest)
+			return
+		}
+		if err := addToCollection(collection, req.RecipeID); err != nil {
+			log.Printf("Failed to add recipe to collection: %v", err)
+			http.Error(w, "Failed to add recipe", http.StatusInternalServerError)
+			return
+		}
+
+		collection, err = getCollection(collection.ID)
+		if err != nil {
+			http.Error(w, "Failed to get collection", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(collection)
+
+	case len(segments) == 3 && segments[1] == "recipes" && r.Method == http.MethodDelete:
+		recipeID, err := strconv.Atoi(segments[2])
+		if err != nil {
+			http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+			return
+		}
+		result, err := db.Exec("DELETE FROM collection_recipes WHERE collection_id = ? AND recipe_id = ?", collection.ID, recipeID)
+		if err != nil {
+			http.Error(w, "Failed to remove recipe", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Recipe not in collection", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func collectionCreateHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	var req CollectionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, err := validCollectionName(userID, req.Name, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := createCollection(userID, name)
+	if err != nil {
+		log.Printf("Failed to create collection: %v", err)
+		http.Error(w, "Failed to create collection", http.StatusInternalServerError)
+		return
+	}
+	collection, err := getCollection(id)
+	if err != nil {
+		http.Error(w, "Failed to get collection", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(collection)
+}
+
+// favoritesPageHandler shows the favorites and collections of the signed in
+// user and handles the forms on the page.
+func favoritesPageHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /favorites/")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Path != "/favorites/" {
+		http.NotFound(w, r)
+		return
+	}
+	userID, ok := requirePageUser(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !validCSRF(r) {
+			http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+			return
+		}
+		message, err := favoritesFormAction(r, userID)
+		if err != nil {
+			log.Printf("Failed to update favorites: %v", err)
+			http.Error(w, "Failed to update favorites", http.StatusInternalServerError)
+			return
+		}
+		setFlash(w, message)
+		http.Redirect(w, r, "/favorites/", http.StatusSeeOther)
+		return
+	}
+
+	favorites, err := getFavoriteRecipes(userID)
+	if err != nil {
+		http.Error(w, "Failed to get favorites", http.StatusInternalServerError)
+		return
+	}
+	collections, err := getCollections(userID)
+	if err != nil {
+		http.Error(w, "Failed to get collections", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Favorites   []RecipeSimple
+		Collections []Collection
+	}{
+		Favorites:   favorites,
+		Collections: collections,
+	}
+
+	renderPage(w, r, "favorites.html", data)
+}
+
+// favoritesFormAction carries out a form posted on the favorites page and
+// returns the message to show. Problems with the input are reported in the
+// message, only database errors are returned.
+func favoritesFormAction(r *http.Request, userID int) (string, error) {
+	recipeID, _ := strconv.Atoi(r.PostFormValue("recipe_id"))
+	var collection *Collection
+	if id, err := strconv.Atoi(r.PostFormValue("collection_id")); err == nil {
+		collection, err = getCollection(id)
+		if err == sql.ErrNoRows || (err == nil && collection.UserID != userID) {
+			return "That collection doesn't exist anymore.", nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch action := r.PostFormValue("action"); {
+	case action == "create_collection":
+		name, err := validCollectionName(userID, r.PostFormValue("name"), 0)
+		if err != nil {
+			return "The collection was not created: " + err.Error() + ".", nil
+		}
+		if _, err := createCollection(userID, name); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Created the collection %q.", name), nil
+
+	case action == "delete_collection" && collection != nil:
+		if err := deleteCollection(collection.ID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted the collection %q, its recipes are still in your favorites.", collection.Name), nil
+
+	case action == "add_to_collection" && collection != nil:
+		title, err := getRecipeTitle(recipeID)
+		if err == sql.ErrNoRows {
+			return "That recipe doesn't exist anymore.", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := addToCollection(collection, recipeID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Added %q to %q.", title, collection.Name), nil
+
+	case action == "remove_from_collection" && collection != nil:
+		if _, err := db.Exec("DELETE FROM collection_recipes WHERE collection_id = ? AND recipe_id = ?", collection.ID, recipeID); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Removed the recipe from %q.", collection.Name), nil
+
+	case action == "remove_favorite":
+		if err := removeFavorite(userID, recipeID); err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		return "Removed the recipe from your favorites.", nil
+	}
+
+	return "Pick a collection first.", nil
+}
+
+// recipeFavoriteHandler adds or removes a favorite with the button on the
+// recipe page.
+func recipeFavoriteHandler(w http.ResponseWriter, r *http.Request, recipeID int) {
+	fmt.Println("Route invoked: POST /recipes/<id>/favorite/")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+	userID, ok := requirePageUser(w, r)
+	if !ok {
+		return
+	}
+
+	title, err := getRecipeTitle(recipeID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	if r.PostFormValue("action") == "remove" {
+		err = removeFavorite(userID, recipeID)
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+		setFlash(w, fmt.Sprintf("Removed %q from your favorites.", title))
+	} else {
+		err = addFavorite(userID, recipeID)
+		setFlash(w, fmt.Sprintf("Added %q to your favorites.", title))
+	}
+	if err != nil {
+		log.Printf("Failed to update favorite: %v", err)
+		http.Error(w, "Failed to update favorites", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/recipes/%d/", recipeID), http.StatusSeeOther)
+}
+
+// validCollectionName trims the name and checks that it is usable and not
+// taken by another collection of the user than the one with exceptID.
+func validCollectionName(userID int, name string, exceptID int) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if len(name) > maxCollectionNameLength {
+		return "", fmt.Errorf("name must be at most %d characters", maxCollectionNameLength)
+	}
+
+	var taken bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM collections WHERE user_id = ? AND name = ? COLLATE NOCASE AND id != ?)",
+		userID, name, exceptID,
+	).Scan(&taken)
+	if err != nil {
+		return "", fmt.Errorf("name could not be checked")
+	}
+	if taken {
+		return "", fmt.Errorf("there is already a collection named %q", name)
+	}
+	return name, nil
+}
+
+// Database helper functions
+func isFavorite(userID, recipeID int) (bool, error) {
+	var favorite bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM favorites WHERE user_id = ? AND recipe_id = ?)", userID, recipeID).Scan(&favorite)
+	return favorite, err
+}
+
+func addFavorite(userID, recipeID int) error {
+	_, err := db.Exec(
+		"INSERT INTO favorites (user_id, recipe_id, created_at) VALUES (?, ?, ?) ON CONFLICT (user_id, recipe_id) DO NOTHING",
+		userID, recipeID, time.Now().UTC().Format(sqliteTime),
+	)
+	return err
+}
+
+// removeFavorite removes a favorite of the user and takes the recipe out of
+// the user's collections.
+func removeFavorite(userID, recipeID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM favorites WHERE user_id = ? AND recipe_id = ?", userID, recipeID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+
+	_, err = tx.Exec(
+		"DELETE FROM collection_recipes WHERE recipe_id = ? AND collection_id IN (SELECT id FROM collections WHERE user_id = ?)",
+		recipeID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func getFavoriteRecipes(userID int) ([]RecipeSimple, error) {
+	recipes, err := getRecipesSimple(&RecipeFilter{FavoritesOf: userID, Sort: "title"})
+	if recipes == nil && err == nil {
+		recipes = []RecipeSimple{}
+	}
+	return recipes, err
+}
+
+func createCollection(userID int, name string) (int, error) {
+	result, err := db.Exec("INSERT INTO collections (user_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func getCollection(id int) (*Collection, error) {
+	var collection Collection
+	err := db.QueryRow("SELECT id, user_id, name FROM collections WHERE id = ?", id).
+		Scan(&collection.ID, &collection.UserID, &collection.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	collection.Recipes, err = queryRecipesSimple(`
+		SELECT id, title, time_minutes, price, link
+		FROM recipes
+		WHERE id IN (SELECT recipe_id FROM collection_recipes WHERE collection_id = ?) AND deleted_at IS NULL
+		ORDER BY title COLLATE NOCASE`, collection.ID)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Recipes == nil {
+		collection.Recipes = []RecipeSimple{}
+	}
+	return &collection, nil
+}
+
+func getCollections(userID int) ([]Collection, error) {
+	rows, err := db.Query("SELECT id FROM collections WHERE user_id = ? ORDER BY name COLLATE NOCASE", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	collections := []Collection{}
+	for _, id := range ids {
+		collection, err := getCollection(id)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, *collection)
+	}
+	return collections, nil
+}
+
+// addToCollection adds a recipe to a collection, making it a favorite of the
+// collection's owner.
+func addToCollection(collection *Collection, recipeID int) error {
+	if err := addFavorite(collection.UserID, recipeID); err != nil {
+		return err
+	}
+	_, err := db.Exec(
+		"INSERT INTO collection_recipes (collection_id, recipe_id) VALUES (?, ?) ON CONFLICT (collection_id, recipe_id) DO NOTHING",
+		collection.ID, recipeID,
+	)
+	return err
+}
+
+func deleteCollection(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM collection_recipes WHERE collection_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM collections WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
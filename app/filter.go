@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RecipeFilter selects and orders recipes for the home page and the recipe
+// list API, from the same query parameters:
+//
+//	tags         tag IDs, comma separated or repeated, recipes need all of them
+//	ingredients  ingredient IDs, the same way
+//	max_time     longest cooking time in minutes
+//	max_price    highest estimated price
+//	q            words that must all appear in the title, instructions or
+//	             ingredient names
+//	favorites    true for the favorites of the signed in user only
+//	sort         one of recipeSortOrders
+//
+// Handlers set FavoritesOf to the signed in user when Favorites is true.
+type RecipeFilter struct {
+	TagIDs        []int
+	IngredientIDs []int
+	MaxTime       int
+	MaxPrice      float64
+	Query         string
+	Favorites     bool
+	FavoritesOf   int
+	Sort          string
+}
+
+// recipeSortOrder is a way to sort recipes, Value is the sort parameter.
+type recipeSortOrder struct {
+	Value   string
+	Label   string
+	orderBy string
+}
+
+// Orders accepted by the sort parameter. Without one recipes are listed in
+// the order they were added.
+var recipeSortOrders = []recipeSortOrder{
+	{"title", "Title", "title COLLATE NOCASE, id"},
+	{"time", "Quickest first", "time_minutes, title COLLATE NOCASE, id"},
+	{"price", "Cheapest first", "CAST(LTRIM(TRIM(price), '$') AS REAL), title COLLATE NOCASE, id"},
+	{"rating", "Best rated", `COALESCE((SELECT AVG(rating) FROM recipe_reviews WHERE recipe_id = recipes.id), 0) DESC,
+		(SELECT COUNT(*) FROM recipe_reviews WHERE recipe_id = recipes.id) DESC, title COLLATE NOCASE, id`},
+	{"newest", "Newest first", "id DESC"},
+}
+
+// parseRecipeFilter reads a filter from query parameters.
+func parseRecipeFilter(query url.Values) (*RecipeFilter, error) {
+	filter := &RecipeFilter{
+		Query: strings.TrimSpace(query.Get("q")),
+		Sort:  query.Get("sort"),
+	}
+
+	var err error
+	if filter.TagIDs, err = parseIDList(query["tags"]); err != nil {
+		return nil, fmt.Errorf("invalid tags: %w", err)
+	}
+	if filter.IngredientIDs, err = parseIDList(query["ingredients"]); err != nil {
+		return nil, fmt.Errorf("invalid ingredients: %w", err)
+	}
+
+	if param := query.Get("max_time"); param != "" {
+		if filter.MaxTime, err = strconv.Atoi(param); err != nil || filter.MaxTime <= 0 {
+			return nil, fmt.Errorf("invalid max_time %q, expected minutes as a whole number", param)
+		}
+	}
+	if param := query.Get("max_price"); param != "" {
+		price, ok := parsePrice(param)
+		if !ok || price <= 0 {
+			return nil, fmt.Errorf("invalid max_price %q, expected a number", param)
+		}
+		filter.MaxPrice = price
+	}
+
+	if param := query.Get("favorites"); param != "" {
+		if filter.Favorites, err = strconv.ParseBool(param); err != nil {
+			return nil, fmt.Errorf("invalid favorites %q, expected true or false", param)
+		}
+	}
+
+	if filter.Sort != "" && !slices.ContainsFunc(recipeSortOrders, func(order recipeSortOrder) bool { return order.Value == filter.Sort }) {
+		var values []string
+		for _, order := range recipeSortOrders {
+			values = append(values, order.Value)
+		}
+		return nil, fmt.Errorf("invalid sort %q, expected one of %s", filter.Sort, strings.Join(values, ", "))
+	}
+
+	return filter, nil
+}
+
+// parseIDList reads IDs given as repeated parameters, comma separated values
+// or both.
+func parseIDList(params []string) ([]int, error) {
+	var ids []int
+	for _, param := range params {
+		for _, field := range strings.Split(param, ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			id, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not an ID", field)
+			}
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// where returns the WHERE clause selecting the recipes of the filter from
+// the recipes table, with its arguments. Recipes in the trash are left out.
+func (f *RecipeFilter) where() (string, []any) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []any
+
+	for _, id := range f.TagIDs {
+		conditions = append(conditions, "id IN (SELECT recipe_id FROM recipe_tags WHERE tag_id = ?)")
+		args = append(args, id)
+	}
+	for _, id := range f.IngredientIDs {
+		conditions = append(conditions, "id IN (SELECT recipe_id FROM recipe_ingredients WHERE ingredient_id = ?)")
+		args = append(args, id)
+	}
+	if f.FavoritesOf > 0 {
+		conditions = append(conditions, "id IN (SELECT recipe_id FROM favorites WHERE user_id = ?)")
+		args = append(args, f.FavoritesOf)
+	}
+	if f.MaxTime > 0 {
+		conditions = append(conditions, "time_minutes <= ?")
+		args = append(args, f.MaxTime)
+	}
+	if f.MaxPrice > 0 {
+		conditions = append(conditions, "CAST(LTRIM(TRIM(price), '$') AS REAL) <= ?")
+		args = append(args, f.MaxPrice)
+	}
+	for _, word := range strings.Fields(f.Query) {
+		pattern := "%" + escapeLike(word) + "%"
+		conditions = append(conditions, `(title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\' OR id IN (
+			SELECT ri.recipe_id FROM recipe_ingredients ri
+			JOIN ingredients i ON i.id = ri.ingredient_id
+			WHERE i.name LIKE ? ESCAPE '\'))`)
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// orderBy returns the ORDER BY expression of the sort order.
+func (f *RecipeFilter) orderBy() string {
+	for _, order := range recipeSortOrders {
+		if order.Value == f.Sort {
+			return order.orderBy
+		}
+	}
+	return "id"
+}
+
+// HasTag reports whether the filter selects recipes with the tag.
+func (f *RecipeFilter) HasTag(id int) bool {
+	return slices.Contains(f.TagIDs, id)
+}
+
+// Active reports whether the filter leaves out any recipes.
+func (f *RecipeFilter) Active() bool {
+	return len(f.TagIDs) > 0 || len(f.IngredientIDs) > 0 || f.MaxTime > 0 || f.MaxPrice > 0 || f.Query != "" || f.Favorites
+}
+
+// SortOrders returns the sort orders offered by the filter form.
+func (f *RecipeFilter) SortOrders() []recipeSortOrder {
+	return recipeSortOrders
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, for use with
+// ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,723 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A group is a household or team that keeps a shared cookbook. Its members
+// have one of groupRoles: viewers see the group and its members, editors
+// also change the group's recipes and owners also manage the group and who
+// is in it. Recipes that belong to a group can only be changed by its
+// editors and owners, recipes without a group by anyone as before. Every
+// recipe stays visible to everyone either way.
+type Group struct {
+	ID        int           `json:"id"`
+	Name      string        `json:"name"`
+	CreatedAt string        `json:"created_at"`
+	Role      string        `json:"role"`
+	Members   []GroupMember `json:"members,omitempty"`
+}
+
+// GroupMember is a user in a group.
+type GroupMember struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+}
+
+type GroupRequest struct {
+	Name string `json:"name"`
+}
+
+// MemberRequest invites the user with Email, or changes the role of a
+// member. Role defaults to viewer for invitations.
+type MemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type GroupRecipeRequest struct {
+	RecipeID int `json:"recipe_id"`
+}
+
+// Member roles from least to most allowed, each allows everything the ones
+// before it do
+var groupRoles = []string{"viewer", "editor", "owner"}
+
+// Longest group name accepted
+const maxGroupNameLength = 100
+
+var (
+	errForbidden = errors.New("forbidden")
+	errLastOwner = errors.New("a group needs at least one owner")
+)
+
+// Handler functions
+func groupsHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /api/groups/")
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/api/groups/")
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			groups, err := getGroups(userID, "viewer")
+			if err != nil {
+				http.Error(w, "Failed to get groups", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(groups)
+		case http.MethodPost:
+			groupCreateHandler(w, r, userID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	groupID, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+
+	// Groups are only known to their members
+	group, err := getGroup(groupID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get group", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(group)
+
+	case len(segments) == 1 && r.Method == http.MethodPatch:
+		if !requireGroupRole(w, group, "owner") {
+			return
+		}
+		var req GroupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		name, err := validGroupName(req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := db.Exec("UPDATE groups SET name = ? WHERE id = ?", name, group.ID); err != nil {
+			http.Error(w, "Failed to rename group", http.StatusInternalServerError)
+			return
+		}
+		group.Name = name
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(group)
+
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		if !requireGroupRole(w, group, "owner") {
+			return
+		}
+		if err := deleteGroup(group.ID); err != nil {
+			log.Printf("Failed to delete group: %v", err)
+			http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) >= 2 && segments[1] == "members":
+		groupMembersHandler(w, r, group, userID, segments[2:])
+
+	case len(segments) >= 2 && segments[1] == "recipes":
+		groupRecipesHandler(w, r, group, userID, segments[2:])
+
+	case len(segments) > 1:
+		http.NotFound(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func groupCreateHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	var req GroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	name, err := validGroupName(req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := createGroup(userID, name)
+	if err != nil {
+		log.Printf("Failed to create group: %v", err)
+		http.Error(w, "Failed to create group", http.StatusInternalServerError)
+		return
+	}
+	group, err := getGroup(id, userID)
+	if err != nil {
+		http.Error(w, "Failed to get group", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// groupMembersHandler serves /api/groups/{id}/members/, segments are the
+// path segments after "members".
+func groupMembersHandler(w http.ResponseWriter, r *http.Request, group *Group, userID int, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(group.Members)
+
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		if !requireGroupRole(w, group, "owner") {
+			return
+		}
+		var req MemberRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = "viewer"
+		}
+		if !slices.Contains(groupRoles, req.Role) {
+			http.Error(w, "role must be one of "+strings.Join(groupRoles, ", "), http.StatusBadRequest)
+			return
+		}
+
+		// Only people who already have an account can be invited
+		memberID, err := getUserIDByEmail(strings.TrimSpace(req.Email))
+		if err == sql.ErrNoRows {
+			http.Error(w, "No user with this email address", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to get user", http.StatusInternalServerError)
+			return
+		}
+		if slices.ContainsFunc(group.Members, func(member GroupMember) bool { return member.UserID == memberID }) {
+			http.Error(w, "This user is already a member of the group", http.StatusConflict)
+			return
+		}
+		if err := addGroupMember(group.ID, memberID, req.Role); err != nil {
+			log.Printf("Failed to add group member: %v", err)
+			http.Error(w, "Failed to add member", http.StatusInternalServerError)
+			return
+		}
+		groupMemberResponse(w, group.ID, userID, memberID, http.StatusCreated)
+
+	case len(segments) == 1 && (r.Method == http.MethodPatch || r.Method == http.MethodDelete):
+		memberID, err := strconv.Atoi(segments[0])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if !slices.ContainsFunc(group.Members, func(member GroupMember) bool { return member.UserID == memberID }) {
+			http.Error(w, "Member not found", http.StatusNotFound)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			// Members can leave, only owners remove others
+			if memberID != userID && !requireGroupRole(w, group, "owner") {
+				return
+			}
+			err = removeGroupMember(group.ID, memberID)
+		} else {
+			if !requireGroupRole(w, group, "owner") {
+				return
+			}
+			var req MemberRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(groupRoles, req.Role) {
+				http.Error(w, "role must be one of "+strings.Join(groupRoles, ", "), http.StatusBadRequest)
+				return
+			}
+			err = setGroupMemberRole(group.ID, memberID, req.Role)
+		}
+		if err == errLastOwner {
+			http.Error(w, "The group needs at least one owner, make someone else owner first", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to change group member: %v", err)
+			http.Error(w, "Failed to change member", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		groupMemberResponse(w, group.ID, userID, memberID, http.StatusOK)
+
+	case len(segments) > 1:
+		http.NotFound(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// groupMemberResponse writes a member of the group as JSON.
+func groupMemberResponse(w http.ResponseWriter, groupID, userID, memberID int, status int) {
+	group, err := getGroup(groupID, userID)
+	if err != nil {
+		http.Error(w, "Failed to get group", http.StatusInternalServerError)
+		return
+	}
+	i := slices.IndexFunc(group.Members, func(member GroupMember) bool { return member.UserID == memberID })
+	if i < 0 {
+		http.Error(w, "Failed to get member", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(group.Members[i])
+}
+
+// groupRecipesHandler serves /api/groups/{id}/recipes/, segments are the
+// path segments after "recipes".
+func groupRecipesHandler(w http.ResponseWriter, r *http.Request, group *Group, userID int, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		recipes, err := getGroupRecipes(group.ID)
+		if err != nil {
+			http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recipes)
+
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		var req GroupRecipeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, err := getRecipeTitle(req.RecipeID); err != nil {
+			http.Error(w, "Recipe not found", http.StatusBadRequest)
+			return
+		}
+		from, err := getRecipeGroupID(req.RecipeID)
+		if err != nil {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		if !requireGroupChange(w, userID, req.RecipeID, from, group.ID) {
+			return
+		}
+		if err := setRecipeGroup(req.RecipeID, group.ID); err != nil {
+			log.Printf("Failed to add recipe to group: %v", err)
+			http.Error(w, "Failed to add recipe", http.StatusInternalServerError)
+			return
+		}
+		recipe, err := getRecipeByID(req.RecipeID)
+		if err != nil {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(recipe)
+
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		recipeID, err := strconv.Atoi(segments[0])
+		if err != nil {
+			http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+			return
+		}
+		from, err := getRecipeGroupID(recipeID)
+		if err != nil || from != group.ID {
+			http.Error(w, "Recipe not in group", http.StatusNotFound)
+			return
+		}
+		if !requireGroupChange(w, userID, recipeID, from, 0) {
+			return
+		}
+		if err := setRecipeGroup(recipeID, 0); err != nil {
+			log.Printf("Failed to remove recipe from group: %v", err)
+			http.Error(w, "Failed to remove recipe", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) > 1:
+		http.NotFound(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requireGroupRole writes a 403 response and returns false unless the
+// signed in user has at least the role in the group.
+func requireGroupRole(w http.ResponseWriter, group *Group, role string) bool {
+	if !hasGroupRole(group.Role, role) {
+		http.Error(w, fmt.Sprintf("Only %ss of the group can do this", role), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// requireGroupChange writes an error response and returns false unless the
+// user may move the recipe from one group to another, see checkGroupChange.
+func requireGroupChange(w http.ResponseWriter, userID, recipeID, from, to int) bool {
+	err := checkRecipeWrite(userID, recipeID)
+	if err == nil {
+		err = checkGroupChange(userID, from, to)
+	}
+	if err == errForbidden {
+		http.Error(w, "Recipes join a group by its editors and owners and leave it by its owners", http.StatusForbidden)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// requireRecipeWrite writes an error response and returns false unless the
+// user sending the request may change the recipe.
+func requireRecipeWrite(w http.ResponseWriter, r *http.Request, recipeID int) bool {
+	err := checkRequestRecipeWrite(r, recipeID)
+	if err == errNotAuthenticated {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Recipe Cookbook"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return false
+	}
+	return writeRecipeWriteError(w, err)
+}
+
+// requireRecipeWritePage is requireRecipeWrite for HTML pages, sending
+// visitors who are not signed in to the login form.
+func requireRecipeWritePage(w http.ResponseWriter, r *http.Request, recipeID int) bool {
+	err := checkRequestRecipeWrite(r, recipeID)
+	if err == errNotAuthenticated {
+		http.Redirect(w, r, "/login/?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return false
+	}
+	return writeRecipeWriteError(w, err)
+}
+
+// writeRecipeWriteError writes the response for an error of
+// checkRecipeWrite other than errNotAuthenticated, and returns whether
+// there was none.
+func writeRecipeWriteError(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case errForbidden:
+		http.Error(w, "Only editors and owners of the recipe's group can change it", http.StatusForbidden)
+	case sql.ErrNoRows:
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// checkRequestRecipeWrite is checkRecipeWrite for the user sending the
+// request.
+func checkRequestRecipeWrite(r *http.Request, recipeID int) error {
+	userID, err := currentUserID(r)
+	if err != nil && err != errNotAuthenticated {
+		return err
+	}
+	return checkRecipeWrite(userID, recipeID)
+}
+
+// checkRecipeWrite returns nil if the user, 0 for nobody, may change the
+// recipe, which may also be in the trash. It returns sql.ErrNoRows if the
+// recipe doesn't exist, and errNotAuthenticated or errForbidden if the
+// recipe belongs to a group the user isn't an editor or owner of.
+func checkRecipeWrite(userID, recipeID int) error {
+	groupID, err := getRecipeGroupID(recipeID)
+	if err != nil {
+		return err
+	}
+	return checkGroupRole(userID, groupID, "editor")
+}
+
+// checkGroupChange returns nil if the user may move a recipe they can change
+// from one group to another, where 0 is no group. Recipes join a group by
+// its editors and owners, and leave it by its owners only, as leaving makes
+// the recipe editable by anyone.
+func checkGroupChange(userID, from, to int) error {
+	if from == to {
+		return nil
+	}
+	if err := checkGroupRole(userID, to, "editor"); err != nil {
+		return err
+	}
+	return checkGroupRole(userID, from, "owner")
+}
+
+// checkGroupRole returns nil if groupID is 0 or the user has at least the
+// role in the group.
+func checkGroupRole(userID, groupID int, role string) error {
+	if groupID == 0 {
+		return nil
+	}
+	if userID == 0 {
+		return errNotAuthenticated
+	}
+	member, err := getGroupRole(groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !hasGroupRole(member, role) {
+		return errForbidden
+	}
+	return nil
+}
+
+// hasGroupRole reports whether a member with the role, "" for someone who
+// isn't a member, has at least the role required.
+func hasGroupRole(role, required string) bool {
+	return role != "" && slices.Index(groupRoles, role) >= slices.Index(groupRoles, required)
+}
+
+// validGroupName trims the name and checks that it is usable.
+func validGroupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if len(name) > maxGroupNameLength {
+		return "", fmt.Errorf("name must be at most %d characters", maxGroupNameLength)
+	}
+	return name, nil
+}
+
+// Database helper functions
+
+// createGroup creates a group with the user as its owner.
+func createGroup(userID int, name string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO groups (name, created_at) VALUES (?, ?)", name, time.Now().UTC().Format(sqliteTime))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO group_members (group_id, user_id, role) VALUES (?, ?, 'owner')", id, userID); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// getGroup returns a group with its members and the role of the user in it,
+// or sql.ErrNoRows if the user isn't a member.
+func getGroup(id, userID int) (*Group, error) {
+	var group Group
+	err := db.QueryRow(`
+		SELECT g.id, g.name, g.created_at, m.role
+		FROM groups g
+		JOIN group_members m ON m.group_id = g.id AND m.user_id = ?
+		WHERE g.id = ?`, userID, id,
+	).Scan(&group.ID, &group.Name, &group.CreatedAt, &group.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT u.id, u.email, u.name, m.role
+		FROM group_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = ?
+		ORDER BY u.name COLLATE NOCASE, u.id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	group.Members = []GroupMember{}
+	for rows.Next() {
+		var member GroupMember
+		if err := rows.Scan(&member.UserID, &member.Email, &member.Name, &member.Role); err != nil {
+			return nil, err
+		}
+		group.Members = append(group.Members, member)
+	}
+	return &group, rows.Err()
+}
+
+// getGroups returns the groups in which the user has at least the role, by
+// name, without their members.
+func getGroups(userID int, role string) ([]Group, error) {
+	rows, err := db.Query(`
+		SELECT g.id, g.name, g.created_at, m.role
+		FROM groups g
+		JOIN group_members m ON m.group_id = g.id
+		WHERE m.user_id = ?
+		ORDER BY g.name COLLATE NOCASE, g.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []Group{}
+	for rows.Next() {
+		var group Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.CreatedAt, &group.Role); err != nil {
+			return nil, err
+		}
+		if hasGroupRole(group.Role, role) {
+			groups = append(groups, group)
+		}
+	}
+	return groups, rows.Err()
+}
+
+// getGroupName returns the name of a group.
+func getGroupName(id int) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM groups WHERE id = ?", id).Scan(&name)
+	return name, err
+}
+
+// getGroupRole returns the role of the user in the group, or "" if they
+// aren't a member.
+func getGroupRole(groupID, userID int) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM group_members WHERE group_id = ? AND user_id = ?", groupID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// getGroupRecipes returns the recipes of a group, by title.
+func getGroupRecipes(groupID int) ([]RecipeSimple, error) {
+	recipes, err := queryRecipesSimple(`
+		SELECT id, title, time_minutes, price, link
+		FROM recipes
+		WHERE group_id = ? AND deleted_at IS NULL
+		ORDER BY title COLLATE NOCASE`, groupID)
+	if recipes == nil && err == nil {
+		recipes = []RecipeSimple{}
+	}
+	return recipes, err
+}
+
+// getUserIDByEmail returns the user with the email address, compared
+// without case.
+func getUserIDByEmail(email string) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM users WHERE email = ? COLLATE NOCASE", email).Scan(&id)
+	return id, err
+}
+
+func addGroupMember(groupID, userID int, role string) error {
+	_, err := db.Exec("INSERT INTO group_members (group_id, user_id, role) VALUES (?, ?, ?)", groupID, userID, role)
+	return err
+}
+
+// setGroupMemberRole changes the role of a member, unless that would leave
+// the group without an owner.
+func setGroupMemberRole(groupID, userID int, role string) error {
+	return changeGroupMember(groupID, userID, "UPDATE group_members SET role = ? WHERE group_id = ? AND user_id = ?", role, groupID, userID)
+}
+
+// removeGroupMember takes a member out of a group, unless that would leave
+// the group without an owner.
+func removeGroupMember(groupID, userID int) error {
+	return changeGroupMember(groupID, userID, "DELETE FROM group_members WHERE group_id = ? AND user_id = ?", groupID, userID)
+}
+
+// changeGroupMember runs the query changing a member and returns
+// errLastOwner instead if afterwards the group would have no owner.
+func changeGroupMember(groupID, userID int, query string, args ...any) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	var owners int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM group_members WHERE group_id = ? AND role = 'owner'", groupID).Scan(&owners); err != nil {
+		return err
+	}
+	if owners == 0 {
+		return errLastOwner
+	}
+	return tx.Commit()
+}
+
+// deleteGroup deletes a group, its recipes are kept without a group.
+func deleteGroup(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"UPDATE recipes SET group_id = NULL WHERE group_id = ?",
+		"DELETE FROM group_members WHERE group_id = ?",
+		"DELETE FROM groups WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// getRecipeGroupID returns the group of a recipe, which may also be in the
+// trash, or 0 if it has none.
+func getRecipeGroupID(recipeID int) (int, error) {
+	var groupID int
+	err := db.QueryRow("SELECT COALESCE(group_id, 0) FROM recipes WHERE id = ?", recipeID).Scan(&groupID)
+	return groupID, err
+}
+
+// setRecipeGroup moves a recipe to a group, or out of its group if groupID
+// is 0.
+func setRecipeGroup(recipeID, groupID int) error {
+	_, err := db.Exec("UPDATE recipes SET group_id = NULLIF(?, 0) WHERE id = ?", groupID, recipeID)
+	return err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Meals start at a fixed time of day so each planned recipe can be placed on
+// the calendar; the event then lasts the recipe's cooking time.
+var mealPlanSlotStart = map[string]int{
+	"breakfast": 8,
+	"lunch":     12,
+	"dinner":    18,
+}
+
+// writeMealPlanICS writes plan as an iCalendar (RFC 5545) document with one
+// event per planned recipe. baseURL is used to link back to the recipe pages.
+func writeMealPlanICS(w io.Writer, plan *MealPlan, baseURL string) error {
+	ics := &icsWriter{w: w}
+
+	ics.line("BEGIN:VCALENDAR")
+	ics.line("VERSION:2.0")
+	ics.line("PRODID:-//Recipe Cookbook//Meal Plan//EN")
+	ics.line("CALSCALE:GREGORIAN")
+	ics.line("X-WR-CALNAME:" + icsEscape("Meal plan - week of "+plan.WeekStart))
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for _, entry := range plan.Entries {
+		day, err := time.Parse(dateLayout, entry.Date)
+		if err != nil {
+			return err
+		}
+		start := day.Add(time.Duration(mealPlanSlotStart[entry.Slot]) * time.Hour)
+
+		ingredients, err := getIngredientsForRecipe(entry.Recipe.ID)
+		if err != nil {
+			return err
+		}
+
+		recipeURL := fmt.Sprintf("%s/recipes/%d/", baseURL, entry.Recipe.ID)
+
+		var description strings.Builder
+		description.WriteString("Ingredients:\n")
+		for _, ing := range ingredients {
+			fmt.Fprintf(&description, "- %s %s %s\n", ing.Amount, ing.Unit, ing.Name)
+		}
+		description.WriteString("\nRecipe: " + recipeURL)
+
+		ics.line("BEGIN:VEVENT")
+		ics.line(fmt.Sprintf("UID:mealplan-%d-entry-%d@recipe-cookbook", plan.ID, entry.ID))
+		ics.line("DTSTAMP:" + stamp)
+		// Floating local time: the meal happens at 18:00 wherever the user is
+		ics.line("DTSTART:" + start.Format("20060102T150405"))
+		ics.line(fmt.Sprintf("DURATION:PT%dM", entry.Recipe.TimeMinutes))
+		ics.line("SUMMARY:" + icsEscape(strings.ToUpper(entry.Slot[:1])+entry.Slot[1:]+": "+entry.Recipe.Title))
+		ics.line("DESCRIPTION:" + icsEscape(description.String()))
+		ics.line("URL:" + recipeURL)
+		ics.line("END:VEVENT")
+	}
+
+	ics.line("END:VCALENDAR")
+	return ics.err
+}
+
+// icsWriter writes CRLF terminated content lines, folding them at 75 octets
+// as required by RFC 5545. The first write error is kept in err.
+type icsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ics *icsWriter) line(s string) {
+	if ics.err != nil {
+		return
+	}
+
+	var folded strings.Builder
+	width := 0
+	for _, r := range s {
+		size := len(string(r))
+		if width+size > 75 {
+			folded.WriteString("\r\n ")
+			width = 1
+		}
+		folded.WriteRune(r)
+		width += size
+	}
+	folded.WriteString("\r\n")
+
+	_, ics.err = io.WriteString(ics.w, folded.String())
+}
+
+// icsEscape escapes a TEXT property value.
+func icsEscape(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// requestBaseURL returns the scheme and host the request was made to, for
+// building absolute links.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
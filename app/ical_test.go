@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestICSWriterLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"short", "BEGIN:VEVENT", "BEGIN:VEVENT\r\n"},
+		{"empty", "", "\r\n"},
+		{"75 octets", strings.Repeat("a", 75), strings.Repeat("a", 75) + "\r\n"},
+		{"76 octets", strings.Repeat("a", 76), strings.Repeat("a", 75) + "\r\n a\r\n"},
+		{
+			// Continuation lines start with a space, leaving 74 octets
+			"two folds",
+			strings.Repeat("a", 75+74+1),
+			strings.Repeat("a", 75) + "\r\n " + strings.Repeat("a", 74) + "\r\n a\r\n",
+		},
+		{
+			// "ø" is two octets and moves to the next line whole
+			"multi-byte rune",
+			strings.Repeat("a", 74) + "øb",
+			strings.Repeat("a", 74) + "\r\n øb\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			ics := &icsWriter{w: &b}
+			ics.line(tt.line)
+			if ics.err != nil {
+				t.Fatal(ics.err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("line(%q) wrote %q, want %q", tt.line, b.String(), tt.want)
+			}
+		})
+	}
+}
+
+type failingWriter struct{ writes int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	return 0, errors.New("disk full")
+}
+
+func TestICSWriterKeepsFirstError(t *testing.T) {
+	f := &failingWriter{}
+	ics := &icsWriter{w: f}
+	ics.line("BEGIN:VCALENDAR")
+	ics.line("END:VCALENDAR")
+	if ics.err == nil || f.writes != 1 {
+		t.Errorf("err = %v after %d writes, want the error of the first write only", ics.err, f.writes)
+	}
+}
+
+func TestICSEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Spaghetti Carbonara", "Spaghetti Carbonara"},
+		{"Salt, pepper; oil", `Salt\, pepper\; oil`},
+		{`C:\recipes`, `C:\\recipes`},
+		{"Line 1\nLine 2", `Line 1\nLine 2`},
+		{"Line 1\r\nLine 2", `Line 1\nLine 2`},
+		{`a\,b`, `a\\\,b`},
+	}
+	for _, tt := range tests {
+		if got := icsEscape(tt.text); got != tt.want {
+			t.Errorf("icsEscape(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
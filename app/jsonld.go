@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RecipeImport is the result of importing a schema.org Recipe: the created
+// recipe and the source lines that could not be mapped onto it.
+type RecipeImport struct {
+	Recipe        *Recipe  `json:"recipe"`
+	UnparsedLines []string `json:"unparsed_lines"`
+}
+
+var (
+	errNoJSONLDRecipe = errors.New("no schema.org Recipe found")
+
+	jsonLDScriptPattern = regexp.MustCompile(`(?is)<script[^>]*type\s*=\s*["']?application/ld\+json["']?[^>]*>(.*?)</script>`)
+	isoDurationPattern  = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+	gluedAmountPattern  = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)([a-zA-Z]+)$`)
+	htmlTagPattern      = regexp.MustCompile(`<[^>]*>`)
+
+	vulgarFractions = strings.NewReplacer(
+		"½", " 1/2", "⅓", " 1/3", "⅔", " 2/3", "¼", " 1/4", "¾", " 3/4", "⅛", " 1/8",
+	)
+
+	// Counted units accepted in ingredient lines next to the weight and volume
+	// units of the unit tables.
+	countUnits = map[string]bool{
+		"piece": true, "clove": true, "large": true, "medium": true, "small": true,
+		"can": true, "slice": true, "pinch": true, "bunch": true, "handful": true,
+		"leave": true, "leaf": true, "fillet": true, "sprig": true, "stalk": true,
+	}
+)
+
+// Handler functions
+func recipeImportHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: POST /api/recipe/recipes/import/")
+
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 5<<20))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	imported, err := importJSONLDRecipe(body)
+	if err != nil {
+		if err == errNoJSONLDRecipe {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			log.Printf("Failed to import recipe: %v", err)
+			http.Error(w, "Failed to import recipe", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(imported)
+}
+
+// importJSONLDRecipe creates a recipe from an HTML page with embedded JSON-LD
+// or from a JSON-LD document.
+func importJSONLDRecipe(data []byte) (*RecipeImport, error) {
+	imported, err := parseJSONLDRecipe(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := createRecipe(imported.Recipe)
+	if err != nil {
+		return nil, err
+	}
+
+	imported.Recipe, err = getRecipeByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// parseJSONLDRecipe maps the first schema.org Recipe found in data onto a
+// Recipe without saving it.
+func parseJSONLDRecipe(data []byte) (*RecipeImport, error) {
+	var documents [][]byte
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		documents = append(documents, trimmed)
+	} else {
+		for _, match := range jsonLDScriptPattern.FindAllSubmatch(data, -1) {
+			documents = append(documents, match[1])
+		}
+	}
+
+	for _, document := range documents {
+		var node any
+		if err := json.Unmarshal(document, &node); err != nil {
+			continue
+		}
+		if recipeNode := findJSONLDRecipe(node); recipeNode != nil {
+			return mapJSONLDRecipe(recipeNode), nil
+		}
+	}
+
+	return nil, errNoJSONLDRecipe
+}
+
+// findJSONLDRecipe searches a JSON-LD value, including arrays and @graph
+// lists, for a node whose @type is Recipe.
+func findJSONLDRecipe(node any) map[string]any {
+	switch v := node.(type) {
+	case []any:
+		for _, item := range v {
+			if found := findJSONLDRecipe(item); found != nil {
+				return found
+			}
+		}
+	case map[string]any:
+		for _, t := range jsonLDStrings(v["@type"]) {
+			if t == "Recipe" || strings.HasSuffix(t, "/Recipe") {
+				return v
+			}
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findJSONLDRecipe(graph)
+		}
+	}
+	return nil
+}
+
+func mapJSONLDRecipe(node map[string]any) *RecipeImport {
+	imported := &RecipeImport{Recipe: &Recipe{}, UnparsedLines: []string{}}
+	recipe := imported.Recipe
+
+	recipe.Title = jsonLDText(node["name"])
+	if recipe.Title == "" {
+		recipe.Title = "Imported recipe"
+		imported.UnparsedLines = append(imported.UnparsedLines, "name: missing")
+	}
+
+	if link := jsonLDText(node["url"]); strings.HasPrefix(link, "http") {
+		recipe.Link = link
+	}
+
+	// Prefer the total time, otherwise add up preparation and cooking
+	if total := jsonLDText(node["totalTime"]); total != "" {
+		minutes, ok := parseISODuration(total)
+		if !ok {
+			imported.UnparsedLines = append(imported.UnparsedLines, "totalTime: "+total)
+		}
+		recipe.TimeMinutes = minutes
+	} else {
+		for _, key := range []string{"prepTime", "cookTime"} {
+			value := jsonLDText(node[key])
+			if value == "" {
+				continue
+			}
+			minutes, ok := parseISODuration(value)
+			if !ok {
+				imported.UnparsedLines = append(imported.UnparsedLines, key+": "+value)
+			}
+			recipe.TimeMinutes += minutes
+		}
+	}
+
+	for _, yield := range jsonLDStrings(node["recipeYield"]) {
+		if servings, err := strconv.Atoi(strings.Fields(yield + " x")[0]); err == nil {
+			recipe.Servings = servings
+			break
+		}
+	}
+
+	for _, line := range jsonLDStrings(node["recipeIngredient"]) {
+		ing, ok := parseIngredientLine(line)
+		if !ok {
+			imported.UnparsedLines = append(imported.UnparsedLines, "recipeIngredient: "+line)
+			continue
+		}
+		recipe.Ingredients = append(recipe.Ingredients, ing)
+	}
+
+	var steps []string
+	for _, step := range jsonLDInstructions(node["recipeInstructions"]) {
+		steps = append(steps, fmt.Sprintf("Step %d: %s", len(steps)+1, step))
+	}
+	recipe.Description = strings.Join(steps, "\n\n")
+
+	return imported
+}
+
+// jsonLDInstructions flattens recipeInstructions, which may be a block of
+// text, a list of strings, HowToStep objects or HowToSection objects.
+func jsonLDInstructions(value any) []string {
+	var steps []string
+	switch v := value.(type) {
+	case string:
+		for _, line := range strings.Split(cleanJSONLDText(v, true), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				steps = append(steps, line)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			steps = append(steps, jsonLDInstructions(item)...)
+		}
+	case map[string]any:
+		if items, ok := v["itemListElement"]; ok {
+			return jsonLDInstructions(items)
+		}
+		text := jsonLDText(v["text"])
+		if text == "" {
+			text = jsonLDText(v["name"])
+		}
+		if text != "" {
+			steps = append(steps, text)
+		}
+	}
+	return steps
+}
+
+// parseIngredientLine splits a line like "1 1/2 cups flour, sifted" into
+// amount, unit and name. Lines without a leading amount are not parsed.
+func parseIngredientLine(line string) (Ingredient, bool) {
+	fields := strings.Fields(vulgarFractions.Replace(cleanJSONLDText(line, false)))
+
+	// "400g" is an amount and a unit written together
+	if len(fields) > 0 {
+		if match := gluedAmountPattern.FindStringSubmatch(fields[0]); match != nil {
+			fields = append([]string{match[1], match[2]}, fields[1:]...)
+		}
+	}
+
+	var amount []string
+	for len(fields) > 0 {
+		field := strings.Replace(fields[0], ",", ".", 1)
+		if _, err := parseAmount(field); err != nil {
+			break
+		}
+		amount = append(amount, field)
+		fields = fields[1:]
+	}
+	if len(amount) == 0 || len(fields) == 0 {
+		return Ingredient{}, false
+	}
+
+	var ing Ingredient
+	ing.Amount = strings.Join(amount, " ")
+
+	unit := normalizeUnit(fields[0])
+	_, isWeight := unitGrams[unit]
+	_, isVolume := unitMilliliters[unit]
+	if isWeight || isVolume || countUnits[unit] {
+		ing.Unit = strings.TrimSuffix(fields[0], ".")
+		fields = fields[1:]
+	} else {
+		ing.Unit = "piece"
+	}
+	if len(fields) > 0 && strings.EqualFold(fields[0], "of") {
+		fields = fields[1:]
+	}
+
+	// Preparation notes after a comma are not part of the ingredient
+	name, _, _ := strings.Cut(strings.Join(fields, " "), ",")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Ingredient{}, false
+	}
+	first, size := utf8.DecodeRuneInString(name)
+	ing.Name = string(unicode.ToUpper(first)) + name[size:]
+
+	return ing, true
+}
+
+// parseISODuration converts an ISO 8601 duration such as "PT1H30M" to
+// minutes. A duration needs at least one value, so "P" and "PT" are invalid.
+func parseISODuration(duration string) (int, bool) {
+	duration = strings.ToUpper(strings.TrimSpace(duration))
+	match := isoDurationPattern.FindStringSubmatch(duration)
+	if match == nil || strings.HasSuffix(duration, "T") || match[1]+match[2]+match[3]+match[4] == "" {
+		return 0, false
+	}
+
+	days, _ := strconv.Atoi(match[1])
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	seconds, _ := strconv.ParseFloat(match[4], 64)
+
+	return days*24*60 + hours*60 + minutes + int(seconds/60), true
+}
+
+// jsonLDStrings returns a JSON-LD value that may be a single value or a list
+// as a list of strings.
+func jsonLDStrings(value any) []string {
+	var values []string
+	switch v := value.(type) {
+	case []any:
+		for _, item := range v {
+			values = append(values, jsonLDStrings(item)...)
+		}
+	case nil:
+	default:
+		if text := jsonLDText(v); text != "" {
+			values = append(values, text)
+		}
+	}
+	return values
+}
+
+// jsonLDText returns a JSON-LD value as plain text. Numbers are formatted and
+// objects contribute their @value, text or name.
+func jsonLDText(value any) string {
+	switch v := value.(type) {
+	case string:
+		return cleanJSONLDText(v, false)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		if len(v) > 0 {
+			return jsonLDText(v[0])
+		}
+	case map[string]any:
+		for _, key := range []string{"@value", "text", "name", "@id"} {
+			if text := jsonLDText(v[key]); text != "" {
+				return text
+			}
+		}
+	}
+	return ""
+}
+
+// cleanJSONLDText strips markup and entities that sites leave in JSON-LD
+// strings. Line breaks are kept if keepLines is set.
+func cleanJSONLDText(s string, keepLines bool) string {
+	s = strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n").Replace(s)
+	s = html.UnescapeString(htmlTagPattern.ReplaceAllString(s, ""))
+	if keepLines {
+		return strings.TrimSpace(s)
+	}
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Diets with a schema.org RestrictedDiet equivalent
+var schemaOrgDiets = map[string]string{
+	"Vegan":       "https://schema.org/VeganDiet",
+	"Vegetarian":  "https://schema.org/VegetarianDiet",
+	"Gluten-Free": "https://schema.org/GlutenFreeDiet",
+}
+
+// recipeJSONLD maps a recipe onto a schema.org Recipe. baseURL is used for
+// the absolute URL of the recipe page.
+func recipeJSONLD(recipe *Recipe, baseURL string) map[string]any {
+	ld := map[string]any{
+		"@context": "https://schema.org",
+		"@type":    "Recipe",
+		"name":     recipe.Title,
+		"url":      fmt.Sprintf("%s/recipes/%d/", baseURL, recipe.ID),
+	}
+
+	if recipe.Link != "" {
+		ld["sameAs"] = recipe.Link
+	}
+	if recipe.ParentRecipeID != 0 {
+		ld["isBasedOn"] = fmt.Sprintf("%s/recipes/%d/", baseURL, recipe.ParentRecipeID)
+	}
+	if recipe.TimeMinutes > 0 {
+		ld["totalTime"] = isoDuration(recipe.TimeMinutes)
+	}
+	if recipe.Servings > 0 {
+		ld["recipeYield"] = strconv.Itoa(recipe.Servings)
+	}
+	if price, ok := parsePrice(recipe.Price); ok {
+		ld["estimatedCost"] = map[string]any{
+			"@type": "MonetaryAmount",
+			"value": price,
+		}
+	}
+
+	if recipe.RatingCount > 0 {
+		ld["aggregateRating"] = map[string]any{
+			"@type":       "AggregateRating",
+			"ratingValue": recipe.AverageRating,
+			"ratingCount": recipe.RatingCount,
+			"bestRating":  5,
+			"worstRating": 1,
+		}
+	}
+
+	ingredients := []string{}
+	for _, ing := range recipe.Ingredients {
+		ingredients = append(ingredients, strings.Join(strings.Fields(ing.Amount+" "+ing.Unit+" "+ing.Name), " "))
+	}
+	ld["recipeIngredient"] = ingredients
+
+	steps := []map[string]any{}
+	for i, step := range recipeSteps(recipe.Description) {
+		steps = append(steps, map[string]any{
+			"@type":    "HowToStep",
+			"position": i + 1,
+			"text":     step,
+		})
+	}
+	ld["recipeInstructions"] = steps
+
+	if len(recipe.Tags) > 0 {
+		var keywords []string
+		for _, tag := range recipe.Tags {
+			keywords = append(keywords, tag.Name)
+		}
+		ld["keywords"] = strings.Join(keywords, ", ")
+	}
+
+	var suitableFor []string
+	for _, d := range recipe.Diets {
+		if url, ok := schemaOrgDiets[d]; ok {
+			suitableFor = append(suitableFor, url)
+		}
+	}
+	if len(suitableFor) > 0 {
+		ld["suitableForDiet"] = suitableFor
+	}
+
+	// schema.org nutrition is per serving
+	if recipe.Nutrition != nil && recipe.Nutrition.PerServing != nil && len(recipe.Nutrition.Unconverted) == 0 {
+		perServing := recipe.Nutrition.PerServing
+		ld["nutrition"] = map[string]any{
+			"@type":               "NutritionInformation",
+			"calories":            fmt.Sprintf("%g kcal", perServing.Kcal),
+			"proteinContent":      fmt.Sprintf("%g g", perServing.Protein),
+			"fatContent":          fmt.Sprintf("%g g", perServing.Fat),
+			"carbohydrateContent": fmt.Sprintf("%g g", perServing.Carbs),
+		}
+	}
+
+	return ld
+}
+
+// recipeSteps splits a recipe description into its steps, dropping the
+// "Step N:" prefixes.
+func recipeSteps(description string) []string {
+	var steps []string
+	for _, step := range strings.Split(description, "\n\n") {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			continue
+		}
+		if prefix, rest, ok := strings.Cut(step, ":"); ok && strings.HasPrefix(prefix, "Step ") {
+			if _, err := strconv.Atoi(strings.TrimPrefix(prefix, "Step ")); err == nil {
+				step = strings.TrimSpace(rest)
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// isoDuration formats minutes as an ISO 8601 duration such as "PT1H30M".
+func isoDuration(minutes int) string {
+	duration := "PT"
+	if minutes >= 60 {
+		duration += strconv.Itoa(minutes/60) + "H"
+	}
+	if minutes%60 > 0 || minutes == 0 {
+		duration += strconv.Itoa(minutes%60) + "M"
+	}
+	return duration
+}
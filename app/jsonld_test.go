@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseJSONLDRecipe(t *testing.T) {
+	tests := []struct {
+		file        string
+		title       string
+		minutes     int
+		servings    int
+		ingredients []Ingredient
+		unparsed    []string
+	}{
+		{
+			file:     "lemon-drizzle-cake.html",
+			title:    "Lemon Drizzle Cake",
+			minutes:  80,
+			servings: 8,
+			ingredients: []Ingredient{
+				{Name: "Unsalted butter", Amount: "225", Unit: "g"},
+				{Name: "Caster sugar", Amount: "225", Unit: "g"},
+				{Name: "Eggs", Amount: "4", Unit: "piece"},
+				{Name: "Self-raising flour", Amount: "1 1/2", Unit: "cups"},
+				{Name: "Lemons", Amount: "2", Unit: "piece"},
+				{Name: "Icing sugar", Amount: "85", Unit: "g"},
+			},
+			unparsed: []string{"recipeIngredient: a pinch of salt"},
+		},
+		{
+			file:     "shakshuka.html",
+			title:    "Easy Shakshuka",
+			minutes:  35,
+			servings: 4,
+			ingredients: []Ingredient{
+				{Name: "Olive oil", Amount: "2", Unit: "tbsp"},
+				{Name: "Onion", Amount: "1", Unit: "piece"},
+				{Name: "Bell peppers", Amount: "2", Unit: "piece"},
+				{Name: "Garlic", Amount: "3", Unit: "cloves"},
+				{Name: "Ground cumin", Amount: "1", Unit: "tsp"},
+				{Name: "Canned tomatoes", Amount: "800", Unit: "g"},
+				{Name: "Eggs", Amount: "6", Unit: "large"},
+				{Name: "Fresh coriander", Amount: "1/2", Unit: "bunch"},
+			},
+			unparsed: []string{"recipeIngredient: Salt and pepper to taste"},
+		},
+		{
+			file:     "tomato-soup.json",
+			title:    "Roasted Tomato Soup",
+			minutes:  70,
+			servings: 6,
+			ingredients: []Ingredient{
+				{Name: "Tomatoes", Amount: "1", Unit: "kg"},
+				{Name: "Red onion", Amount: "1", Unit: "piece"},
+				{Name: "Garlic", Amount: "4", Unit: "cloves"},
+				{Name: "Olive oil", Amount: "3", Unit: "tbsp"},
+				{Name: "Vegetable stock", Amount: "500", Unit: "ml"},
+				{Name: "Basil", Amount: "15", Unit: "leaves"},
+			},
+			unparsed: []string{"recipeIngredient: salt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "jsonld", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported, err := parseJSONLDRecipe(data)
+			if err != nil {
+				t.Fatalf("parseJSONLDRecipe: %v", err)
+			}
+
+			recipe := imported.Recipe
+			if recipe.Title != tt.title {
+				t.Errorf("title = %q, want %q", recipe.Title, tt.title)
+			}
+			if recipe.TimeMinutes != tt.minutes {
+				t.Errorf("time = %d minutes, want %d", recipe.TimeMinutes, tt.minutes)
+			}
+			if recipe.Servings != tt.servings {
+				t.Errorf("servings = %d, want %d", recipe.Servings, tt.servings)
+			}
+			if !slices.Equal(recipe.Ingredients, tt.ingredients) {
+				t.Errorf("ingredients = %+v, want %+v", recipe.Ingredients, tt.ingredients)
+			}
+			if !slices.Equal(imported.UnparsedLines, tt.unparsed) {
+				t.Errorf("unparsed lines = %q, want %q", imported.UnparsedLines, tt.unparsed)
+			}
+			if recipe.Description == "" {
+				t.Error("instructions are empty")
+			}
+		})
+	}
+}
+
+func TestParseJSONLDRecipeWithoutRecipe(t *testing.T) {
+	_, err := parseJSONLDRecipe([]byte(`<html><script type="application/ld+json">{"@type": "Person"}</script></html>`))
+	if err != errNoJSONLDRecipe {
+		t.Errorf("error = %v, want %v", err, errNoJSONLDRecipe)
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		minutes  int
+		ok       bool
+	}{
+		{"PT45M", 45, true},
+		{"PT1H30M", 90, true},
+		{"pt2h", 120, true},
+		{"P1DT2H", 1560, true},
+		{"PT90S", 1, true},
+		{"PT0M", 0, true},
+		{"45 minutes", 0, false},
+		{"", 0, false},
+		{"P", 0, false},
+		{"PT", 0, false},
+		{"P1DT", 0, false},
+	}
+	for _, tt := range tests {
+		minutes, ok := parseISODuration(tt.duration)
+		if minutes != tt.minutes || ok != tt.ok {
+			t.Errorf("parseISODuration(%q) = %d, %v, want %d, %v", tt.duration, minutes, ok, tt.minutes, tt.ok)
+		}
+	}
+}
+
+func TestParseIngredientLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want Ingredient
+		ok   bool
+	}{
+		{"1 1/2 cups flour, sifted", Ingredient{Name: "Flour", Amount: "1 1/2", Unit: "cups"}, true},
+		{"400g spaghetti", Ingredient{Name: "Spaghetti", Amount: "400", Unit: "g"}, true},
+		{"½ tsp salt", Ingredient{Name: "Salt", Amount: "1/2", Unit: "tsp"}, true},
+		{"2 tbsp. of olive oil", Ingredient{Name: "Olive oil", Amount: "2", Unit: "tbsp"}, true},
+		{"3 eggs", Ingredient{Name: "Eggs", Amount: "3", Unit: "piece"}, true},
+		{"4 æbler", Ingredient{Name: "Æbler", Amount: "4", Unit: "piece"}, true},
+		{"200 g øllebrød", Ingredient{Name: "Øllebrød", Amount: "200", Unit: "g"}, true},
+		{"salt to taste", Ingredient{}, false},
+		{"2", Ingredient{}, false},
+		{"NaN eggs", Ingredient{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseIngredientLine(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseIngredientLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
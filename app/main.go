@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 
@@ -21,8 +25,18 @@ type Recipe struct {
 	Price       string  `json:"price"`
 	Link        string  `json:"link"`
 	Description string  `json:"description"`
+	Servings    int     `json:"servings"`
+	ParentRecipeID int  `json:"parent_recipe_id,omitempty"`
+	GroupID     int     `json:"group_id,omitempty"`
 	Ingredients []Ingredient `json:"ingredients"`
 	Tags        []Tag   `json:"tags"`
+	Nutrition   *RecipeNutrition `json:"nutrition,omitempty"`
+	Cost        *RecipeCost `json:"cost,omitempty"`
+	Allergens   []string `json:"allergens"`
+	Diets       []string `json:"diets"`
+	DietWarnings []string `json:"diet_warnings,omitempty"`
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int     `json:"rating_count"`
 }
 
 type RecipeSimple struct {
@@ -33,6 +47,8 @@ type RecipeSimple struct {
 	Link        string  `json:"link"`
 	Ingredients []Ingredient `json:"ingredients"`
 	Tags        []Tag   `json:"tags"`
+	AverageRating float64 `json:"average_rating"`
+	RatingCount   int     `json:"rating_count"`
 }
 
 type Ingredient struct {
@@ -71,13 +87,25 @@ type RecipeImage struct {
 // Global variables
 var (
 	db          *sql.DB
-	templates   *template.Template
+	templates   map[string]map[string]*template.Template
 	databasePath = "./demo.db"
+
+	errNotAuthenticated = errors.New("not authenticated")
 )
 
 func main() {
 	var err error
 
+	// The default theme can be set with -theme or the COOKBOOK_THEME
+	// environment variable, visitors may pick another one
+	if theme := os.Getenv("COOKBOOK_THEME"); theme != "" {
+		defaultTheme = theme
+	}
+	flag.StringVar(&defaultTheme, "theme", defaultTheme, "theme shown to visitors who haven't picked one")
+	flag.BoolVar(&devMode, "dev", false, "read templates and static files from disk and reload changed templates")
+	flag.Int64Var(&maxImportMB, "max-import-mb", maxImportMB, "largest cookbook archive accepted by the import API, in megabytes")
+	flag.Parse()
+
 	// Initialize database
 	db, err = sql.Open("sqlite3", databasePath)
 	if err != nil {
@@ -88,27 +116,101 @@ func main() {
 	// Initialize database schema
 	initDB()
 
-	// Create template function map
-	funcMap := template.FuncMap{
-		"add": func(a, b int) int { return a + b },
-		"split": strings.Split,
+	// Run a command line subcommand instead of the server if one is given
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Load templates with custom functions
-	templates = template.Must(template.New("").Funcs(funcMap).ParseGlob("templates/*.html"))
+	// Empty the trash in the background while the server runs
+	go purgeTrashPeriodically()
+
+	// Load the themes and the page templates of each theme
+	shared, static, themeDir, err := siteFiles()
+	if err != nil {
+		log.Fatal("Failed to load site files:", err)
+	}
+	if err := loadThemes(shared, themeDir); err != nil {
+		log.Fatal("Failed to load themes:", err)
+	}
 
 	// Set up static file server
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+	http.HandleFunc("/static/themes/", themeStaticHandler)
 
 	// Set up routes
 	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/recipes/", recipeDetailHandler)
+	http.HandleFunc("/recipes/", func(w http.ResponseWriter, r *http.Request) {
+		segments := pathSegments(r.URL.Path, "/recipes/")
+		if len(segments) == 1 && segments[0] == "cards.pdf" {
+			var ids []string
+			if param := r.URL.Query().Get("ids"); param != "" {
+				ids = strings.Split(param, ",")
+			}
+			recipeCardsHandler(w, r, ids)
+		} else if len(segments) == 2 && segments[1] == "card.pdf" {
+			recipeCardsHandler(w, r, segments[:1])
+		} else if len(segments) == 1 && segments[0] == "new" {
+			recipeFormHandler(w, r, 0)
+		} else if len(segments) == 2 && (segments[1] == "edit" || segments[1] == "delete" || segments[1] == "reviews" || segments[1] == "favorite" || segments[1] == "variation") {
+			id, err := strconv.Atoi(segments[0])
+			if err != nil || id <= 0 {
+				http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+				return
+			}
+			switch segments[1] {
+			case "edit":
+				recipeFormHandler(w, r, id)
+			case "delete":
+				recipeDeleteHandler(w, r, id)
+			case "reviews":
+				reviewFormHandler(w, r, id)
+			case "favorite":
+				recipeFavoriteHandler(w, r, id)
+			case "variation":
+				recipeVariationFormHandler(w, r, id)
+			}
+		} else {
+			recipeDetailHandler(w, r)
+		}
+	})
+	http.HandleFunc("/tags/", tagPageHandler)
+	http.HandleFunc("/ingredients/", ingredientPageHandler)
+	http.HandleFunc("/mealplans/", mealPlanPageHandler)
+	http.HandleFunc("/favorites/", favoritesPageHandler)
+	http.HandleFunc("/shared/", sharedRecipeHandler)
+	http.HandleFunc("/login/", loginHandler)
+	http.HandleFunc("/signup/", signupHandler)
+	http.HandleFunc("/logout/", logoutHandler)
 	http.HandleFunc("/api", apiOverviewHandler)
 	http.HandleFunc("/api/user/create/", userCreateHandler)
 	http.HandleFunc("/api/user/me/", userMeHandler)
 	http.HandleFunc("/api/user/token/", userTokenHandler)
 	http.HandleFunc("/api/recipe/recipes/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
+		segments := pathSegments(r.URL.Path, "/api/recipe/recipes/")
+		if len(segments) == 1 && segments[0] == "import" && r.Method == http.MethodPost {
+			recipeImportHandler(w, r)
+		} else if len(segments) == 1 && segments[0] == "csv" {
+			recipeCSVHandler(w, r)
+		} else if len(segments) == 1 && segments[0] == "trash" {
+			recipeTrashHandler(w, r)
+		} else if len(segments) == 1 && r.Method == http.MethodGet {
+			recipeRecipeDetailHandler(w, r, segments[0])
+		} else if len(segments) == 1 && r.Method == http.MethodDelete {
+			recipeDestroyHandler(w, r, segments[0])
+		} else if len(segments) == 2 && segments[1] == "restore" {
+			recipeRestoreHandler(w, r, segments[0])
+		} else if len(segments) == 2 && segments[1] == "variations" {
+			recipeVariationsHandler(w, r, segments[0])
+		} else if len(segments) == 2 && segments[1] == "reviews" {
+			recipeReviewsHandler(w, r, segments[0])
+		} else if len(segments) >= 2 && segments[1] == "revisions" {
+			recipeRevisionsHandler(w, r, segments[0], segments[2:])
+		} else if len(segments) > 0 {
+			http.NotFound(w, r)
+		} else if r.Method == http.MethodGet {
 			recipeRecipesHandler(w, r)
 		} else if r.Method == http.MethodPost {
 			recipeRecipesCreateHandler(w, r)
@@ -116,12 +218,34 @@ func main() {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
-	http.HandleFunc("/api/recipe/ingredients/", recipeIngredientsHandler)
+	http.HandleFunc("/api/recipe/ingredients/", func(w http.ResponseWriter, r *http.Request) {
+		segments := pathSegments(r.URL.Path, "/api/recipe/ingredients/")
+		if len(segments) == 1 && segments[0] == "csv" {
+			ingredientCSVHandler(w, r)
+		} else if len(segments) == 2 && segments[1] == "classification" {
+			ingredientClassificationHandler(w, r, segments[0])
+		} else if len(segments) == 2 && segments[1] == "price" {
+			ingredientPriceHandler(w, r, segments[0])
+		} else if len(segments) == 2 && segments[1] == "nutrition" {
+			ingredientNutritionHandler(w, r, segments[0])
+		} else if len(segments) > 0 {
+			http.NotFound(w, r)
+		} else {
+			recipeIngredientsHandler(w, r)
+		}
+	})
 	http.HandleFunc("/api/recipe/tags/", recipeTagsHandler)
+	http.HandleFunc("/api/mealplans/", mealPlansHandler)
+	http.HandleFunc("/api/favorites/", favoritesHandler)
+	http.HandleFunc("/api/collections/", collectionsHandler)
+	http.HandleFunc("/api/shares/", sharesHandler)
+	http.HandleFunc("/api/groups/", groupsHandler)
+	http.HandleFunc("/api/cookbook/export/", cookbookExportHandler)
+	http.HandleFunc("/api/cookbook/import/", cookbookImportHandler)
 
 	// Start server
 	fmt.Println("Server starting on :3000...")
-	log.Fatal(http.ListenAndServe(":3000", nil))
+	log.Fatal(http.ListenAndServe(":3000", refreshSessions(cacheUser(http.DefaultServeMux))))
 }
 
 func initDB() {
@@ -140,7 +264,8 @@ func initDB() {
 		price TEXT NOT NULL,
 		link TEXT,
 		description TEXT,
-		image TEXT
+		image TEXT,
+		servings INTEGER
 	);
 
 	CREATE TABLE IF NOT EXISTS ingredients (
@@ -167,6 +292,137 @@ func initDB() {
 		tag_id INTEGER,
 		FOREIGN KEY (recipe_id) REFERENCES recipes(id),
 		FOREIGN KEY (tag_id) REFERENCES tags(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS ingredient_nutrition (
+		ingredient_id INTEGER PRIMARY KEY,
+		kcal REAL NOT NULL,
+		protein REAL NOT NULL,
+		fat REAL NOT NULL,
+		carbs REAL NOT NULL,
+		grams_per_ml REAL NOT NULL DEFAULT 1,
+		FOREIGN KEY (ingredient_id) REFERENCES ingredients(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS ingredient_prices (
+		ingredient_id INTEGER PRIMARY KEY,
+		price REAL NOT NULL,
+		quantity REAL NOT NULL,
+		unit TEXT NOT NULL,
+		FOREIGN KEY (ingredient_id) REFERENCES ingredients(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS ingredient_allergens (
+		ingredient_id INTEGER NOT NULL,
+		allergen TEXT NOT NULL,
+		UNIQUE (ingredient_id, allergen),
+		FOREIGN KEY (ingredient_id) REFERENCES ingredients(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS ingredient_diet_flags (
+		ingredient_id INTEGER NOT NULL,
+		flag TEXT NOT NULL,
+		UNIQUE (ingredient_id, flag),
+		FOREIGN KEY (ingredient_id) REFERENCES ingredients(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS meal_plans (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		week_start TEXT NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS meal_plan_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		meal_plan_id INTEGER NOT NULL,
+		day INTEGER NOT NULL,
+		slot TEXT NOT NULL,
+		recipe_id INTEGER NOT NULL,
+		UNIQUE (meal_plan_id, day, slot),
+		FOREIGN KEY (meal_plan_id) REFERENCES meal_plans(id),
+		FOREIGN KEY (recipe_id) REFERENCES recipes(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS recipe_reviews (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		recipe_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+		text TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL,
+		UNIQUE (recipe_id, user_id),
+		FOREIGN KEY (recipe_id) REFERENCES recipes(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS favorites (
+		user_id INTEGER NOT NULL,
+		recipe_id INTEGER NOT NULL,
+		created_at TEXT NOT NULL,
+		PRIMARY KEY (user_id, recipe_id),
+		FOREIGN KEY (user_id) REFERENCES users(id),
+		FOREIGN KEY (recipe_id) REFERENCES recipes(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS collections (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS collection_recipes (
+		collection_id INTEGER NOT NULL,
+		recipe_id INTEGER NOT NULL,
+		UNIQUE (collection_id, recipe_id),
+		FOREIGN KEY (collection_id) REFERENCES collections(id),
+		FOREIGN KEY (recipe_id) REFERENCES recipes(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS recipe_revisions (
+		recipe_id INTEGER NOT NULL,
+		number INTEGER NOT NULL,
+		created_at TEXT NOT NULL,
+		snapshot TEXT NOT NULL,
+		PRIMARY KEY (recipe_id, number),
+		FOREIGN KEY (recipe_id) REFERENCES recipes(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS recipe_shares (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		token_hash TEXT NOT NULL UNIQUE,
+		recipe_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		created_at TEXT NOT NULL,
+		expires_at TEXT,
+		FOREIGN KEY (recipe_id) REFERENCES recipes(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS group_members (
+		group_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+		PRIMARY KEY (group_id, user_id),
+		FOREIGN KEY (group_id) REFERENCES groups(id),
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		created_at TEXT NOT NULL,
+		expires_at TEXT NOT NULL,
+		replaced_at TEXT,
+		FOREIGN KEY (user_id) REFERENCES users(id)
 	);`
 
 	_, err := db.Exec(schema)
@@ -174,6 +430,12 @@ func initDB() {
 		log.Fatal("Failed to create tables:", err)
 	}
 
+	// Columns added after the first release, for databases created before them
+	addColumnIfMissing("recipes", "servings", "INTEGER")
+	addColumnIfMissing("recipes", "deleted_at", "TEXT")
+	addColumnIfMissing("recipes", "parent_recipe_id", "INTEGER REFERENCES recipes(id)")
+	addColumnIfMissing("recipes", "group_id", "INTEGER REFERENCES groups(id)")
+
 	// Check if we need to seed data
 	var count int
 	err = db.QueryRow("SELECT COUNT(*) FROM recipes").Scan(&count)
@@ -184,6 +446,69 @@ func initDB() {
 	if count == 0 {
 		seedDatabase()
 	}
+
+	if err := recordMissingRevisions(); err != nil {
+		log.Fatal("Failed to record recipe revisions:", err)
+	}
+
+	err = db.QueryRow("SELECT COUNT(*) FROM ingredient_nutrition").Scan(&count)
+	if err != nil {
+		log.Fatal("Failed to check nutrition count:", err)
+	}
+
+	if count == 0 {
+		seedNutrition()
+	}
+
+	err = db.QueryRow("SELECT (SELECT COUNT(*) FROM ingredient_allergens) + (SELECT COUNT(*) FROM ingredient_diet_flags)").Scan(&count)
+	if err != nil {
+		log.Fatal("Failed to check allergen count:", err)
+	}
+
+	if count == 0 {
+		seedAllergens()
+	}
+
+	err = db.QueryRow("SELECT COUNT(*) FROM ingredient_prices").Scan(&count)
+	if err != nil {
+		log.Fatal("Failed to check price count:", err)
+	}
+
+	if count == 0 {
+		seedPrices()
+	}
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already
+// there.
+func addColumnIfMissing(table, column, definition string) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		log.Fatal("Failed to read table info:", err)
+	}
+
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			log.Fatal("Failed to read table info:", err)
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+
+	if found {
+		return
+	}
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	if err != nil {
+		log.Fatal("Failed to add column "+table+"."+column+":", err)
+	}
 }
 
 func seedDatabase() {
@@ -220,6 +545,7 @@ func seedDatabase() {
 		price       string
 		link        string
 		description string
+		servings    int
 	}{
 		{
 			title:       "Spaghetti Carbonara",
@@ -227,6 +553,7 @@ func seedDatabase() {
 			price:       "12.50",
 			link:        "http://example.com/carbonara",
 			description: "Step 1: Bring a large pot of salted water to boil and cook 400g spaghetti according to package directions.\n\nStep 2: While pasta cooks, cut 200g pancetta into small cubes and fry in a large pan over medium heat until crispy (about 5 minutes).\n\nStep 3: In a bowl, whisk together 4 large eggs, 100g grated Parmesan cheese, and plenty of black pepper.\n\nStep 4: When pasta is ready, reserve 1 cup of pasta water, then drain the pasta.\n\nStep 5: Remove the pan with pancetta from heat. Add the hot pasta to the pan and toss.\n\nStep 6: Pour the egg mixture over the pasta and toss quickly. The heat from the pasta will cook the eggs. Add pasta water bit by bit if needed to create a creamy sauce.\n\nStep 7: Serve immediately with extra Parmesan cheese and black pepper.",
+			servings:    4,
 		},
 		{
 			title:       "Chicken Parmesan",
@@ -234,6 +561,7 @@ func seedDatabase() {
 			price:       "18.00",
 			link:        "http://example.com/chicken-parm",
 			description: "Step 1: Preheat oven to 200C (400F).\n\nStep 2: Place 2 chicken breasts between plastic wrap and pound to 2cm thickness.\n\nStep 3: Set up breading station: flour in one plate, 2 beaten eggs in another, and 150g breadcrumbs mixed with 50g Parmesan in a third.\n\nStep 4: Season chicken with salt and pepper, then coat in flour, dip in egg, and press into breadcrumb mixture.\n\nStep 5: Heat 3 tablespoons olive oil in a large oven-safe skillet over medium-high heat. Fry chicken until golden brown, about 4 minutes per side.\n\nStep 6: Pour 300ml tomato sauce over the chicken, then top each breast with 100g sliced mozzarella.\n\nStep 7: Transfer skillet to oven and bake for 15-20 minutes until cheese is melted and bubbly.\n\nStep 8: Garnish with fresh basil and serve with pasta or salad.",
+			servings:    2,
 		},
 		{
 			title:       "Pasta Primavera",
@@ -241,6 +569,7 @@ func seedDatabase() {
 			price:       "10.00",
 			link:        "http://example.com/primavera",
 			description: "Step 1: Cook 350g penne pasta in salted boiling water according to package directions. Reserve 1 cup pasta water before draining.\n\nStep 2: While pasta cooks, chop 1 red bell pepper, 1 zucchini into bite-sized pieces, and halve 200g cherry tomatoes.\n\nStep 3: Heat 3 tablespoons olive oil in a large pan over medium-high heat. Add 3 minced garlic cloves and cook for 30 seconds.\n\nStep 4: Add bell peppers and zucchini to the pan. Cook for 5-7 minutes until vegetables are tender.\n\nStep 5: Add cherry tomatoes and cook for another 2-3 minutes until they start to soften.\n\nStep 6: Add the drained pasta to the pan with vegetables. Toss everything together, adding pasta water as needed to create a light sauce.\n\nStep 7: Season with salt and black pepper. Remove from heat and stir in fresh basil leaves.\n\nStep 8: Serve hot with grated Parmesan cheese on top.",
+			servings:    4,
 		},
 		{
 			title:       "Garlic Butter Salmon",
@@ -248,13 +577,14 @@ func seedDatabase() {
 			price:       "22.00",
 			link:        "http://example.com/salmon",
 			description: "Step 1: Pat 4 salmon fillets (150g each) dry with paper towels and season both sides with salt and pepper.\n\nStep 2: Heat 2 tablespoons olive oil in a large skillet over medium-high heat.\n\nStep 3: Place salmon fillets skin-side up in the pan. Cook for 4-5 minutes until golden brown.\n\nStep 4: Flip the salmon and cook for another 3-4 minutes.\n\nStep 5: Reduce heat to medium and add 3 tablespoons butter, 4 minced garlic cloves, and juice of 1 lemon to the pan.\n\nStep 6: Spoon the garlic butter sauce over the salmon repeatedly for 1-2 minutes.\n\nStep 7: Remove from heat and sprinkle with fresh dill.\n\nStep 8: Serve immediately with the pan sauce, accompanied by rice or vegetables.",
+			servings:    4,
 		},
 	}
 
 	for _, recipe := range recipes {
 		result, err := db.Exec(
-			"INSERT INTO recipes (title, time_minutes, price, link, description) VALUES (?, ?, ?, ?, ?)",
-			recipe.title, recipe.timeMinutes, recipe.price, recipe.link, recipe.description,
+			"INSERT INTO recipes (title, time_minutes, price, link, description, servings) VALUES (?, ?, ?, ?, ?, ?)",
+			recipe.title, recipe.timeMinutes, recipe.price, recipe.link, recipe.description, recipe.servings,
 		)
 		if err != nil {
 			log.Printf("Failed to insert recipe %s: %v", recipe.title, err)
@@ -344,23 +674,49 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	recipes, err := getAllRecipesSimple()
+	// An invalid filter is reported above the form and the full list shown
+	status := http.StatusOK
+	filter, err := parseRecipeFilter(r.URL.Query())
+	var filterError string
+	if err != nil {
+		status, filterError, filter = http.StatusBadRequest, err.Error(), &RecipeFilter{}
+	}
+	if filter.Sort == "" {
+		filter.Sort = "title"
+	}
+	if filter.Favorites {
+		userID, ok := requirePageUser(w, r)
+		if !ok {
+			return
+		}
+		filter.FavoritesOf = userID
+	}
+
+	recipes, err := getRecipesSimple(filter)
 	if err != nil {
 		http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
 		return
 	}
 
+	tagCloud, err := getTagCloud()
+	if err != nil {
+		http.Error(w, "Failed to get tags", http.StatusInternalServerError)
+		return
+	}
+
 	data := struct {
-		Recipes []RecipeSimple
+		Recipes     []RecipeSimple
+		TagCloud    []TagCount
+		Filter      *RecipeFilter
+		FilterError string
 	}{
-		Recipes: recipes,
+		Recipes:     recipes,
+		TagCloud:    tagCloud,
+		Filter:      filter,
+		FilterError: filterError,
 	}
 
-	err = templates.ExecuteTemplate(w, "home.html", data)
-	if err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Failed to render template: "+err.Error(), http.StatusInternalServerError)
-	}
+	renderPageStatus(w, r, status, "home.html", data)
 }
 
 func recipeDetailHandler(w http.ResponseWriter, r *http.Request) {
@@ -394,11 +750,121 @@ func recipeDetailHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = templates.ExecuteTemplate(w, "recipe_detail.html", recipe)
+	writeRecipePage(w, r, recipe, false)
+}
+
+// writeRecipePage writes the page of a recipe as HTML, Markdown or plain
+// text. A read only page, for a share link, leaves out everything that
+// changes the recipe, links to other recipe, tag and ingredient pages and
+// the recipe's own address, which the link is meant to stand in for.
+func writeRecipePage(w http.ResponseWriter, r *http.Request, recipe *Recipe, readOnly bool) {
+	// The same page is served as HTML, Markdown or plain text
+	w.Header().Set("Vary", "Accept")
+	switch recipePageFormat(r) {
+	case formatMarkdown:
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		writeRecipeMarkdown(w, recipe)
+		return
+	case formatText:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writeRecipeText(w, recipe)
+		return
+	}
+
+	reviews, err := getReviewsForRecipe(recipe.ID)
 	if err != nil {
-		log.Printf("Template execution error: %v", err)
-		http.Error(w, "Failed to render template: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Failed to get reviews", http.StatusInternalServerError)
+		return
 	}
+
+	// A variation links to the recipe it is based on, unless that was deleted
+	var parent *RecipeSimple
+	var variations []RecipeSimple
+	if !readOnly {
+		if recipe.ParentRecipeID != 0 {
+			title, err := getRecipeTitle(recipe.ParentRecipeID)
+			if err == nil {
+				parent = &RecipeSimple{ID: recipe.ParentRecipeID, Title: title}
+			} else if err != sql.ErrNoRows {
+				http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+				return
+			}
+		}
+		variations, err = getVariations(recipe.ID)
+		if err != nil {
+			http.Error(w, "Failed to get variations", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Only the editors and owners of the recipe's group see the links to
+	// change it, and only signed in users change recipes
+	userID, err := currentUserID(r)
+	if err != nil && err != errNotAuthenticated {
+		http.Error(w, "Failed to authenticate user", http.StatusInternalServerError)
+		return
+	}
+	var group string
+	canEdit := !readOnly && userID != 0
+	if recipe.GroupID != 0 && !readOnly {
+		if group, err = getGroupName(recipe.GroupID); err != nil {
+			http.Error(w, "Failed to get group", http.StatusInternalServerError)
+			return
+		}
+		err = checkRecipeWrite(userID, recipe.ID)
+		if err != nil && err != errNotAuthenticated && err != errForbidden {
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+		canEdit = err == nil
+	}
+
+	// The signed in user's own review fills in the review form
+	var myReview *Review
+	var favorite bool
+	if userID != 0 && !readOnly {
+		for i := range reviews {
+			if reviews[i].UserID == userID {
+				myReview = &reviews[i]
+			}
+		}
+		if favorite, err = isFavorite(userID, recipe.ID); err != nil {
+			http.Error(w, "Failed to get favorites", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	jsonLD := recipeJSONLD(recipe, requestBaseURL(r))
+	if readOnly {
+		delete(jsonLD, "url")
+		delete(jsonLD, "isBasedOn")
+	}
+
+	data := struct {
+		*Recipe
+		JSONLD     map[string]any
+		Reviews    []Review
+		MyReview   *Review
+		Favorite   bool
+		Parent     *RecipeSimple
+		Variations []RecipeSimple
+		Group      string
+		CanEdit    bool
+		ReadOnly   bool
+	}{
+		Recipe:     recipe,
+		JSONLD:     jsonLD,
+		Reviews:    reviews,
+		MyReview:   myReview,
+		Favorite:   favorite,
+		Parent:     parent,
+		Variations: variations,
+		Group:      group,
+		CanEdit:    canEdit,
+		ReadOnly:   readOnly,
+	}
+
+	renderPage(w, r, "recipe_detail.html", data)
 }
 
 func apiOverviewHandler(w http.ResponseWriter, r *http.Request) {
@@ -413,13 +879,37 @@ func apiOverviewHandler(w http.ResponseWriter, r *http.Request) {
 		"create_user_url":       "http://localhost:3000/api/user/create/",
 		"current_user_url":      "http://localhost:3000/api/user/me/",
 		"user_token_url":        "http://localhost:3000/api/user/token/",
-		"recipes_url":           "http://localhost:3000/api/recipe/recipes/{?ingredients,tags}",
-		"recipe_url":           "http://localhost:3000/api/recipe/recipes/{id}/",
+		"recipes_url":           "http://localhost:3000/api/recipe/recipes/{?ingredients,tags,exclude_allergens}",
+		"recipe_url":           "http://localhost:3000/api/recipe/recipes/{id}/{?format}",
 		"recipe_image_url":     "http://localhost:3000/api/recipe/recipes/{id}/upload-image/",
+		"recipe_revisions_url": "http://localhost:3000/api/recipe/recipes/{id}/revisions/",
+		"recipe_variations_url": "http://localhost:3000/api/recipe/recipes/{id}/variations/",
+		"recipe_import_url":    "http://localhost:3000/api/recipe/recipes/import/",
+		"recipes_csv_url":      "http://localhost:3000/api/recipe/recipes/csv/{?dry_run}",
+		"recipes_trash_url":    "http://localhost:3000/api/recipe/recipes/trash/",
+		"recipe_restore_url":   "http://localhost:3000/api/recipe/recipes/{id}/restore/",
 		"ingredients_url":      "http://localhost:3000/api/recipe/ingredients/{?assigned_only}",
 		"ingredient_url":       "http://localhost:3000/api/recipe/ingredients/{id}/",
+		"ingredients_csv_url":  "http://localhost:3000/api/recipe/ingredients/csv/{?dry_run}",
+		"ingredient_classification_url": "http://localhost:3000/api/recipe/ingredients/{id}/classification/",
+		"ingredient_price_url":  "http://localhost:3000/api/recipe/ingredients/{id}/price/",
+		"ingredient_nutrition_url": "http://localhost:3000/api/recipe/ingredients/{id}/nutrition/",
 		"tags_url":              "http://localhost:3000/api/recipe/tags/{?assigned_only}",
 		"tag_url":               "http://localhost:3000/api/recipe/tags/{id}/",
+		"meal_plans_url":        "http://localhost:3000/api/mealplans/",
+		"meal_plan_url":         "http://localhost:3000/api/mealplans/{id}/",
+		"meal_plan_entries_url": "http://localhost:3000/api/mealplans/{id}/entries/",
+		"favorites_url":         "http://localhost:3000/api/favorites/",
+		"collections_url":       "http://localhost:3000/api/collections/",
+		"collection_url":        "http://localhost:3000/api/collections/{id}/",
+		"shares_url":            "http://localhost:3000/api/shares/{?recipe_id}",
+		"share_url":             "http://localhost:3000/api/shares/{id}/",
+		"groups_url":            "http://localhost:3000/api/groups/",
+		"group_url":             "http://localhost:3000/api/groups/{id}/",
+		"group_members_url":     "http://localhost:3000/api/groups/{id}/members/",
+		"group_recipes_url":     "http://localhost:3000/api/groups/{id}/recipes/",
+		"cookbook_export_url":   "http://localhost:3000/api/cookbook/export/{?format}",
+		"cookbook_import_url":   "http://localhost:3000/api/cookbook/import/",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -441,10 +931,16 @@ func userCreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	hash, err := hashPassword(userReq.Password)
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+
 	// Insert user into database
 	_, err = db.Exec(
 		"INSERT INTO users (email, password, name) VALUES (?, ?, ?)",
-		userReq.Email, userReq.Password, userReq.Name,
+		userReq.Email, hash, userReq.Name,
 	)
 	if err != nil {
 		log.Printf("Failed to create user: %v", err)
@@ -539,6 +1035,97 @@ func userTokenHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(authReq)
 }
 
+// requestUser is the user of a request once currentUserID has resolved it,
+// so Basic credentials are hashed once however often handlers ask.
+type requestUser struct {
+	resolved bool
+	id       int
+	err      error
+}
+
+type requestUserKey struct{}
+
+// cacheUser wraps the site's handler to give every request a requestUser.
+func cacheUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestUserKey{}, &requestUser{})))
+	})
+}
+
+// currentUserID resolves the user sending the request from the session
+// cookie of a signed in browser, or from HTTP Basic credentials checked
+// against the users table.
+func currentUserID(r *http.Request) (int, error) {
+	cached, ok := r.Context().Value(requestUserKey{}).(*requestUser)
+	if !ok {
+		return resolveUserID(r)
+	}
+	if !cached.resolved {
+		cached.id, cached.err = resolveUserID(r)
+		cached.resolved = true
+	}
+	return cached.id, cached.err
+}
+
+func resolveUserID(r *http.Request) (int, error) {
+	session, err := getSession(r)
+	if err == nil {
+		return session.UserID, nil
+	}
+	if err != errNotAuthenticated {
+		return 0, err
+	}
+
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		return 0, errNotAuthenticated
+	}
+	return authenticate(email, password)
+}
+
+// requireUser writes a 401 response and returns false if the request is not
+// authenticated.
+func requireUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := currentUserID(r)
+	if err == errNotAuthenticated {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Recipe Cookbook"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return 0, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to authenticate user", http.StatusInternalServerError)
+		return 0, false
+	}
+	return userID, true
+}
+
+// pathSegments returns the non-empty segments of the URL path after prefix,
+// so "/api/mealplans/3/entries/" with prefix "/api/mealplans/" gives
+// ["3", "entries"].
+func pathSegments(path, prefix string) []string {
+	var segments []string
+	for _, part := range strings.Split(strings.TrimPrefix(path, prefix), "/") {
+		if part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return segments
+}
+
+// writeJSON writes v as a JSON response. It is encoded before anything is
+// sent, so a value that can't be encoded gives a 500 instead of an empty
+// 200 response.
+func writeJSON(w http.ResponseWriter, contentType string, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(append(body, '\n'))
+}
+
 func recipeRecipesHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Route invoked: GET /api/recipe/recipes/")
 	
@@ -547,18 +1134,70 @@ func recipeRecipesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse query parameters (currently unused but kept for API compatibility)
-	_ = r.URL.Query().Get("ingredients")
-	_ = r.URL.Query().Get("tags")
+	filter, err := parseRecipeFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Favorites {
+		userID, ok := requireUser(w, r)
+		if !ok {
+			return
+		}
+		filter.FavoritesOf = userID
+	}
+
+	var excludeAllergens []string
+	if param := r.URL.Query().Get("exclude_allergens"); param != "" {
+		for _, allergen := range strings.Split(param, ",") {
+			excludeAllergens = append(excludeAllergens, strings.ToLower(strings.TrimSpace(allergen)))
+		}
+	}
 
-	recipes, err := getAllRecipes()
+	recipes, err := getRecipes(filter)
 	if err != nil {
 		http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(recipes)
+	if len(excludeAllergens) > 0 {
+		filtered := []Recipe{}
+		for _, recipe := range recipes {
+			if !hasAnyAllergen(recipe, excludeAllergens) {
+				filtered = append(filtered, recipe)
+			}
+		}
+		recipes = filtered
+	}
+
+	writeJSON(w, "application/json", recipes)
+}
+
+func recipeRecipeDetailHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: GET /api/recipe/recipes/<id>/")
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+
+	recipe, err := getRecipeByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Recipe not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.URL.Query().Get("format") == "jsonld" {
+		writeJSON(w, "application/ld+json", recipeJSONLD(recipe, requestBaseURL(r)))
+		return
+	}
+
+	writeJSON(w, "application/json", recipe)
 }
 
 func recipeRecipesCreateHandler(w http.ResponseWriter, r *http.Request) {
@@ -577,6 +1216,8 @@ func recipeRecipesCreateHandler(w http.ResponseWriter, r *http.Request) {
 		Tags        []Tag         `json:"tags"`
 		Ingredients []Ingredient  `json:"ingredients"`
 		Description string        `json:"description"`
+		Servings    int           `json:"servings"`
+		GroupID     int           `json:"group_id"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&recipeReq)
@@ -585,10 +1226,34 @@ func recipeRecipesCreateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only editors and owners of a group add recipes to it
+	if recipeReq.GroupID != 0 {
+		userID, ok := requireUser(w, r)
+		if !ok {
+			return
+		}
+		err := checkGroupRole(userID, recipeReq.GroupID, "editor")
+		if err == errForbidden {
+			http.Error(w, "Only editors and owners of the group can add recipes to it", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create recipe", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	// Insert recipe into database
-	result, err := db.Exec(
-		"INSERT INTO recipes (title, time_minutes, price, link, description) VALUES (?, ?, ?, ?, ?)",
-		recipeReq.Title, recipeReq.TimeMinutes, recipeReq.Price, recipeReq.Link, recipeReq.Description,
+	result, err := tx.Exec(
+		"INSERT INTO recipes (title, time_minutes, price, link, description, servings, group_id) VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0))",
+		recipeReq.Title, recipeReq.TimeMinutes, recipeReq.Price, recipeReq.Link, recipeReq.Description, recipeReq.Servings, recipeReq.GroupID,
 	)
 	if err != nil {
 		http.Error(w, "Failed to create recipe", http.StatusInternalServerError)
@@ -596,6 +1261,14 @@ func recipeRecipesCreateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	recipeID, _ := result.LastInsertId()
+	if err := recordRevision(tx, int(recipeID)); err != nil {
+		http.Error(w, "Failed to create recipe", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create recipe", http.StatusInternalServerError)
+		return
+	}
 
 	// Return the created recipe
 	recipe := Recipe{
@@ -607,6 +1280,8 @@ func recipeRecipesCreateHandler(w http.ResponseWriter, r *http.Request) {
 		Tags:        recipeReq.Tags,
 		Ingredients: recipeReq.Ingredients,
 		Description: recipeReq.Description,
+		Servings:    recipeReq.Servings,
+		GroupID:     recipeReq.GroupID,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -680,7 +1355,18 @@ func recipeTagsHandler(w http.ResponseWriter, r *http.Request) {
 
 // Database helper functions
 func getAllRecipesSimple() ([]RecipeSimple, error) {
-	rows, err := db.Query("SELECT id, title, time_minutes, price, link FROM recipes")
+	return getRecipesSimple(&RecipeFilter{})
+}
+
+func getRecipesSimple(filter *RecipeFilter) ([]RecipeSimple, error) {
+	where, args := filter.where()
+	return queryRecipesSimple("SELECT id, title, time_minutes, price, link FROM recipes"+where+" ORDER BY "+filter.orderBy(), args...)
+}
+
+// queryRecipesSimple runs a query selecting the id, title, time_minutes,
+// price and link of recipes and adds their tags.
+func queryRecipesSimple(query string, args ...any) ([]RecipeSimple, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -699,6 +1385,11 @@ func getAllRecipesSimple() ([]RecipeSimple, error) {
 			return nil, err
 		}
 
+		recipe.AverageRating, recipe.RatingCount, err = getRatingForRecipe(recipe.ID)
+		if err != nil {
+			return nil, err
+		}
+
 		recipes = append(recipes, recipe)
 	}
 
@@ -706,7 +1397,12 @@ func getAllRecipesSimple() ([]RecipeSimple, error) {
 }
 
 func getAllRecipes() ([]Recipe, error) {
-	rows, err := db.Query("SELECT id, title, time_minutes, price, link, description FROM recipes")
+	return getRecipes(&RecipeFilter{})
+}
+
+func getRecipes(filter *RecipeFilter) ([]Recipe, error) {
+	where, args := filter.where()
+	rows, err := db.Query("SELECT id, title, time_minutes, price, link, description, COALESCE(servings, 0) FROM recipes"+where+" ORDER BY "+filter.orderBy(), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -715,7 +1411,7 @@ func getAllRecipes() ([]Recipe, error) {
 	var recipes []Recipe
 	for rows.Next() {
 		var recipe Recipe
-		if err := rows.Scan(&recipe.ID, &recipe.Title, &recipe.TimeMinutes, &recipe.Price, &recipe.Link, &recipe.Description); err != nil {
+		if err := rows.Scan(&recipe.ID, &recipe.Title, &recipe.TimeMinutes, &recipe.Price, &recipe.Link, &recipe.Description, &recipe.Servings); err != nil {
 			return nil, err
 		}
 
@@ -731,6 +1427,28 @@ func getAllRecipes() ([]Recipe, error) {
 			return nil, err
 		}
 
+		recipe.AverageRating, recipe.RatingCount, err = getRatingForRecipe(recipe.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Compute nutrition from the ingredient amounts
+		recipe.Nutrition, err = getNutritionForRecipe(recipe.Ingredients, recipe.Servings)
+		if err != nil {
+			return nil, err
+		}
+
+		// Derive allergens and diets from the ingredients
+		if err := classifyRecipe(&recipe); err != nil {
+			return nil, err
+		}
+
+		// Compare the stored price with the ingredient prices
+		recipe.Cost, err = getCostForRecipe(recipe.Ingredients, recipe.Price)
+		if err != nil {
+			return nil, err
+		}
+
 		recipes = append(recipes, recipe)
 	}
 
@@ -739,8 +1457,8 @@ func getAllRecipes() ([]Recipe, error) {
 
 func getRecipeByID(id int) (*Recipe, error) {
 	var recipe Recipe
-	err := db.QueryRow("SELECT id, title, time_minutes, price, link, description FROM recipes WHERE id = ?", id).
-		Scan(&recipe.ID, &recipe.Title, &recipe.TimeMinutes, &recipe.Price, &recipe.Link, &recipe.Description)
+	err := db.QueryRow("SELECT id, title, time_minutes, price, link, description, COALESCE(servings, 0), COALESCE(parent_recipe_id, 0), COALESCE(group_id, 0) FROM recipes WHERE id = ? AND deleted_at IS NULL", id).
+		Scan(&recipe.ID, &recipe.Title, &recipe.TimeMinutes, &recipe.Price, &recipe.Link, &recipe.Description, &recipe.Servings, &recipe.ParentRecipeID, &recipe.GroupID)
 	if err != nil {
 		return nil, err
 	}
@@ -757,6 +1475,28 @@ func getRecipeByID(id int) (*Recipe, error) {
 		return nil, err
 	}
 
+	recipe.AverageRating, recipe.RatingCount, err = getRatingForRecipe(recipe.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compute nutrition from the ingredient amounts
+	recipe.Nutrition, err = getNutritionForRecipe(recipe.Ingredients, recipe.Servings)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive allergens and diets from the ingredients
+	if err := classifyRecipe(&recipe); err != nil {
+		return nil, err
+	}
+
+	// Compare the stored price with the ingredient prices
+	recipe.Cost, err = getCostForRecipe(recipe.Ingredients, recipe.Price)
+	if err != nil {
+		return nil, err
+	}
+
 	return &recipe, nil
 }
 
@@ -804,4 +1544,193 @@ func getTagsForRecipe(recipeID int) ([]Tag, error) {
 	}
 
 	return tags, nil
-}
\ No newline at end of file
+}
+
+// createRecipe inserts a recipe with its ingredients and tags and returns the
+// new recipe ID. Ingredients and tags without an ID are matched by name and
+// created if they don't exist yet.
+func createRecipe(recipe *Recipe) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	recipeID, err := insertRecipe(tx, recipe)
+	if err != nil {
+		return 0, err
+	}
+	return recipeID, tx.Commit()
+}
+
+// insertRecipe is createRecipe within an existing transaction.
+func insertRecipe(tx *sql.Tx, recipe *Recipe) (int, error) {
+	result, err := tx.Exec(
+		"INSERT INTO recipes (title, time_minutes, price, link, description, servings, parent_recipe_id, group_id) VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0), NULLIF(?, 0))",
+		recipe.Title, recipe.TimeMinutes, recipe.Price, recipe.Link, recipe.Description, recipe.Servings, recipe.ParentRecipeID, recipe.GroupID,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	recipeID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := insertRecipeLinks(tx, int(recipeID), recipe); err != nil {
+		return 0, err
+	}
+	if err := recordRevision(tx, int(recipeID)); err != nil {
+		return 0, err
+	}
+	return int(recipeID), nil
+}
+
+// insertRecipeLinks adds the ingredients and tags of a recipe.
+func insertRecipeLinks(tx *sql.Tx, recipeID int, recipe *Recipe) error {
+	var err error
+	for _, ing := range recipe.Ingredients {
+		ingredientID := ing.ID
+		if ingredientID == 0 {
+			ingredientID, err = findOrCreateIngredient(tx, ing.Name)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(
+			"INSERT INTO recipe_ingredients (recipe_id, ingredient_id, amount, unit) VALUES (?, ?, ?, ?)",
+			recipeID, ingredientID, ing.Amount, ing.Unit,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range recipe.Tags {
+		tagID := tag.ID
+		if tagID == 0 {
+			tagID, err = findOrCreateTag(tx, tag.Name)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("INSERT INTO recipe_tags (recipe_id, tag_id) VALUES (?, ?)", recipeID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateRecipe replaces the fields, group, ingredients and tags of a recipe.
+func updateRecipe(id int, recipe *Recipe) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE recipes SET title = ?, time_minutes = ?, price = ?, link = ?, description = ?, servings = ?, group_id = NULLIF(?, 0) WHERE id = ? AND deleted_at IS NULL",
+		recipe.Title, recipe.TimeMinutes, recipe.Price, recipe.Link, recipe.Description, recipe.Servings, recipe.GroupID, id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recipe_ingredients WHERE recipe_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recipe_tags WHERE recipe_id = ?", id); err != nil {
+		return err
+	}
+	if err := insertRecipeLinks(tx, id, recipe); err != nil {
+		return err
+	}
+	if err := recordRevision(tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// purgeRecipe removes a recipe for good, together with its ingredient and
+// tag links, its history and everything else that refers to it.
+func purgeRecipe(id int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM recipe_ingredients WHERE recipe_id = ?",
+		"DELETE FROM recipe_tags WHERE recipe_id = ?",
+		"DELETE FROM meal_plan_entries WHERE recipe_id = ?",
+		"DELETE FROM recipe_reviews WHERE recipe_id = ?",
+		"DELETE FROM favorites WHERE recipe_id = ?",
+		"DELETE FROM collection_recipes WHERE recipe_id = ?",
+		"DELETE FROM recipe_revisions WHERE recipe_id = ?",
+		"DELETE FROM recipe_shares WHERE recipe_id = ?",
+		"UPDATE recipes SET parent_recipe_id = NULL WHERE parent_recipe_id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM recipes WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// findOrCreateIngredient returns the ID of the ingredient with the given name,
+// compared without case, creating it if needed.
+func findOrCreateIngredient(tx *sql.Tx, name string) (int, error) {
+	var id int
+	err := tx.QueryRow("SELECT id FROM ingredients WHERE name = ? COLLATE NOCASE", name).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	result, err := tx.Exec("INSERT INTO ingredients (name) VALUES (?)", name)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	return int(newID), err
+}
+
+// findOrCreateTag returns the ID of the tag with the given name, compared
+// without case, creating it if needed.
+func findOrCreateTag(tx *sql.Tx, name string) (int, error) {
+	var id int
+	err := tx.QueryRow("SELECT id FROM tags WHERE name = ? COLLATE NOCASE", name).Scan(&id)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	result, err := tx.Exec("INSERT INTO tags (name) VALUES (?)", name)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := result.LastInsertId()
+	return int(newID), err
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Formats a recipe page can be rendered in besides HTML.
+const (
+	formatHTML     = "html"
+	formatMarkdown = "md"
+	formatText     = "text"
+)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+)
+
+// Characters that would end an autolink, percent-encoded
+var autolinkEscaper = strings.NewReplacer(
+	"<", "%3C", ">", "%3E", " ", "%20", "\t", "%09", "\n", "%0A", "\r", "%0D",
+)
+
+// recipePageFormat picks the format of a recipe page from the format query
+// parameter or else from the Accept header. Browsers ask for text/html first,
+// so HTML stays the default.
+func recipePageFormat(r *http.Request) string {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "md", "markdown":
+		return formatMarkdown
+	case "txt", "text":
+		return formatText
+	case "html":
+		return formatHTML
+	}
+
+	best, bestQuality := formatHTML, 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(accepted, ";")
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		var format string
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case "text/html", "application/xhtml+xml":
+			format = formatHTML
+		case "text/markdown", "text/x-markdown":
+			format = formatMarkdown
+		case "text/plain":
+			format = formatText
+		default:
+			continue
+		}
+		if quality > bestQuality {
+			best, bestQuality = format, quality
+		}
+	}
+	return best
+}
+
+// writeRecipeMarkdown renders a recipe as Markdown with an ingredient list and
+// numbered steps.
+func writeRecipeMarkdown(w io.Writer, recipe *Recipe) {
+	fmt.Fprintf(w, "# %s\n\n", markdownEscaper.Replace(recipe.Title))
+
+	// Lines end in two spaces, a Markdown line break
+	details := []string{fmt.Sprintf("**Time:** %d minutes", recipe.TimeMinutes)}
+	if recipe.Price != "" {
+		details = append(details, "**Price:** $"+markdownEscaper.Replace(recipe.Price))
+	}
+	if recipe.Servings > 0 {
+		details = append(details, fmt.Sprintf("**Servings:** %d", recipe.Servings))
+	}
+	if len(recipe.Tags) > 0 {
+		var names []string
+		for _, tag := range recipe.Tags {
+			names = append(names, markdownEscaper.Replace(tag.Name))
+		}
+		details = append(details, "**Tags:** "+strings.Join(names, ", "))
+	}
+	if len(recipe.Allergens) > 0 {
+		details = append(details, "**Allergens:** "+strings.Join(recipe.Allergens, ", "))
+	}
+	if recipe.Link != "" {
+		details = append(details, "**Source:** "+markdownLink(recipe.Link))
+	}
+	fmt.Fprintf(w, "%s\n", strings.Join(details, "  \n"))
+
+	if len(recipe.Ingredients) > 0 {
+		fmt.Fprint(w, "\n## Ingredients\n\n")
+		for _, ing := range recipe.Ingredients {
+			fmt.Fprintf(w, "- %s\n", markdownEscaper.Replace(ingredientLine(ing)))
+		}
+	}
+
+	if steps := recipeSteps(recipe.Description); len(steps) > 0 {
+		fmt.Fprint(w, "\n## Steps\n\n")
+		for i, step := range steps {
+			// Continuation lines are indented to stay within the list item
+			step = strings.ReplaceAll(markdownEscaper.Replace(step), "\n", "\n   ")
+			fmt.Fprintf(w, "%d. %s\n", i+1, step)
+		}
+	}
+}
+
+// writeRecipeText renders a recipe as plain text laid out like the Markdown
+// version, without any markup.
+func writeRecipeText(w io.Writer, recipe *Recipe) {
+	fmt.Fprintf(w, "%s\n%s\n\n", recipe.Title, strings.Repeat("=", len([]rune(recipe.Title))))
+
+	fmt.Fprintf(w, "Time: %d minutes\n", recipe.TimeMinutes)
+	if recipe.Price != "" {
+		fmt.Fprintf(w, "Price: $%s\n", recipe.Price)
+	}
+	if recipe.Servings > 0 {
+		fmt.Fprintf(w, "Servings: %d\n", recipe.Servings)
+	}
+	if len(recipe.Tags) > 0 {
+		var names []string
+		for _, tag := range recipe.Tags {
+			names = append(names, tag.Name)
+		}
+		fmt.Fprintf(w, "Tags: %s\n", strings.Join(names, ", "))
+	}
+	if len(recipe.Allergens) > 0 {
+		fmt.Fprintf(w, "Allergens: %s\n", strings.Join(recipe.Allergens, ", "))
+	}
+	if recipe.Link != "" {
+		fmt.Fprintf(w, "Source: %s\n", recipe.Link)
+	}
+
+	if len(recipe.Ingredients) > 0 {
+		fmt.Fprint(w, "\nIngredients\n-----------\n")
+		for _, ing := range recipe.Ingredients {
+			fmt.Fprintf(w, "- %s\n", ingredientLine(ing))
+		}
+	}
+
+	if steps := recipeSteps(recipe.Description); len(steps) > 0 {
+		fmt.Fprint(w, "\nSteps\n-----\n")
+		for i, step := range steps {
+			fmt.Fprintf(w, "%d. %s\n", i+1, strings.ReplaceAll(step, "\n", "\n   "))
+		}
+	}
+}
+
+// ingredientLine formats an ingredient as "400 g Spaghetti".
+func ingredientLine(ing Ingredient) string {
+	var parts []string
+	for _, part := range []string{ing.Amount, ing.Unit, ing.Name} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// markdownLink writes an http or https link as an autolink and anything else
+// as escaped text on one line.
+func markdownLink(link string) string {
+	link = strings.TrimSpace(link)
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return markdownEscaper.Replace(strings.Join(strings.Fields(link), " "))
+	}
+	return "<" + autolinkEscaper.Replace(link) + ">"
+}
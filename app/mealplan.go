@@ -0,0 +1,438 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meal plan structure
+type MealPlan struct {
+	ID               int             `json:"id"`
+	UserID           int             `json:"user_id"`
+	WeekStart        string          `json:"week_start"`
+	Entries          []MealPlanEntry `json:"entries"`
+	TotalCost        string          `json:"total_cost"`
+	TotalTimeMinutes int             `json:"total_time_minutes"`
+	UnpricedEntries  int             `json:"unpriced_entries"`
+}
+
+type MealPlanEntry struct {
+	ID     int          `json:"id"`
+	Day    int          `json:"day"`
+	Date   string       `json:"date"`
+	Slot   string       `json:"slot"`
+	Recipe RecipeSimple `json:"recipe"`
+}
+
+type MealPlanEntryRequest struct {
+	Day      int    `json:"day"`
+	Slot     string `json:"slot"`
+	RecipeID int    `json:"recipe_id"`
+}
+
+const dateLayout = "2006-01-02"
+
+// Days are stored as 0-6 starting on Monday, slots in the order they are eaten.
+var (
+	mealPlanDays  = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+	mealPlanSlots = []string{"breakfast", "lunch", "dinner"}
+)
+
+// Handler functions
+func mealPlansHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /api/mealplans/")
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/api/mealplans/")
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			mealPlansListHandler(w, userID)
+		case http.MethodPost:
+			mealPlanCreateHandler(w, r, userID)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	planID, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid meal plan ID", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := getMealPlan(planID)
+	if err == sql.ErrNoRows || (err == nil && plan.UserID != userID) {
+		http.Error(w, "Meal plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get meal plan", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plan)
+
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		if err := deleteMealPlan(plan.ID); err != nil {
+			http.Error(w, "Failed to delete meal plan", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) == 2 && segments[1] == "entries" && r.Method == http.MethodPost:
+		mealPlanEntryCreateHandler(w, r, plan.ID)
+
+	case len(segments) == 3 && segments[1] == "entries" && r.Method == http.MethodDelete:
+		entryID, err := strconv.Atoi(segments[2])
+		if err != nil {
+			http.Error(w, "Invalid entry ID", http.StatusBadRequest)
+			return
+		}
+		result, err := db.Exec("DELETE FROM meal_plan_entries WHERE id = ? AND meal_plan_id = ?", entryID, plan.ID)
+		if err != nil {
+			http.Error(w, "Failed to delete entry", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Entry not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func mealPlansListHandler(w http.ResponseWriter, userID int) {
+	rows, err := db.Query("SELECT id FROM meal_plans WHERE user_id = ? ORDER BY week_start", userID)
+	if err != nil {
+		http.Error(w, "Failed to get meal plans", http.StatusInternalServerError)
+		return
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Failed to scan meal plan: %v", err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	plans := []MealPlan{}
+	for _, id := range ids {
+		plan, err := getMealPlan(id)
+		if err != nil {
+			http.Error(w, "Failed to get meal plans", http.StatusInternalServerError)
+			return
+		}
+		plans = append(plans, *plan)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
+
+func mealPlanCreateHandler(w http.ResponseWriter, r *http.Request, userID int) {
+	var planReq struct {
+		WeekStart string `json:"week_start"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&planReq)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	day := time.Now()
+	if planReq.WeekStart != "" {
+		day, err = time.Parse(dateLayout, planReq.WeekStart)
+		if err != nil {
+			http.Error(w, "Invalid week_start, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO meal_plans (user_id, week_start) VALUES (?, ?)",
+		userID, weekStartFor(day).Format(dateLayout),
+	)
+	if err != nil {
+		http.Error(w, "Failed to create meal plan", http.StatusInternalServerError)
+		return
+	}
+
+	planID, _ := result.LastInsertId()
+	plan, err := getMealPlan(int(planID))
+	if err != nil {
+		http.Error(w, "Failed to get meal plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(plan)
+}
+
+func mealPlanEntryCreateHandler(w http.ResponseWriter, r *http.Request, planID int) {
+	var entryReq MealPlanEntryRequest
+	err := json.NewDecoder(r.Body).Decode(&entryReq)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if entryReq.Day < 0 || entryReq.Day >= len(mealPlanDays) {
+		http.Error(w, "Invalid day, expected 0 (Monday) to 6 (Sunday)", http.StatusBadRequest)
+		return
+	}
+	entryReq.Slot = strings.ToLower(entryReq.Slot)
+	if !isMealPlanSlot(entryReq.Slot) {
+		http.Error(w, "Invalid slot, expected one of "+strings.Join(mealPlanSlots, ", "), http.StatusBadRequest)
+		return
+	}
+	if _, err := getRecipeByID(entryReq.RecipeID); err != nil {
+		http.Error(w, "Recipe not found", http.StatusBadRequest)
+		return
+	}
+
+	// A slot holds a single recipe, so assigning to it again replaces it
+	_, err = db.Exec(`
+		INSERT INTO meal_plan_entries (meal_plan_id, day, slot, recipe_id) VALUES (?, ?, ?, ?)
+		ON CONFLICT (meal_plan_id, day, slot) DO UPDATE SET recipe_id = excluded.recipe_id`,
+		planID, entryReq.Day, entryReq.Slot, entryReq.RecipeID,
+	)
+	if err != nil {
+		log.Printf("Failed to add meal plan entry: %v", err)
+		http.Error(w, "Failed to add entry", http.StatusInternalServerError)
+		return
+	}
+
+	plan, err := getMealPlan(planID)
+	if err != nil {
+		http.Error(w, "Failed to get meal plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(plan)
+}
+
+func mealPlanPageHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: GET " + r.URL.Path)
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/mealplans/")
+	if len(segments) == 0 || len(segments) > 2 || (len(segments) == 2 && segments[1] != "plan.ics") {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Calendar apps fetching the feed sign in with HTTP Basic, browsers
+	// opening the page are sent to the login form
+	var userID int
+	var ok bool
+	if len(segments) == 2 {
+		userID, ok = requireUser(w, r)
+	} else {
+		userID, ok = requirePageUser(w, r)
+	}
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid meal plan ID", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := getMealPlan(id)
+	if err == sql.ErrNoRows || (err == nil && plan.UserID != userID) {
+		http.Error(w, "Meal plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get meal plan", http.StatusInternalServerError)
+		return
+	}
+
+	if len(segments) == 2 {
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="mealplan-%s.ics"`, plan.WeekStart))
+		if err := writeMealPlanICS(w, plan, requestBaseURL(r)); err != nil {
+			log.Printf("Failed to write calendar: %v", err)
+		}
+		return
+	}
+
+	renderPage(w, r, "mealplan.html", newMealPlanPage(plan))
+}
+
+// mealPlanPage lays a plan out as a calendar grid with one row per slot and
+// one cell per day.
+type mealPlanPage struct {
+	Plan *MealPlan
+	Days []mealPlanDay
+	Rows []mealPlanRow
+}
+
+type mealPlanDay struct {
+	Name string
+	Date string
+}
+
+type mealPlanRow struct {
+	Slot  string
+	Cells []*MealPlanEntry
+}
+
+func newMealPlanPage(plan *MealPlan) mealPlanPage {
+	page := mealPlanPage{Plan: plan}
+
+	weekStart, _ := time.Parse(dateLayout, plan.WeekStart)
+	for i, name := range mealPlanDays {
+		page.Days = append(page.Days, mealPlanDay{
+			Name: name,
+			Date: weekStart.AddDate(0, 0, i).Format("Jan 2"),
+		})
+	}
+
+	for _, slot := range mealPlanSlots {
+		row := mealPlanRow{Slot: slot, Cells: make([]*MealPlanEntry, len(mealPlanDays))}
+		for i := range plan.Entries {
+			if plan.Entries[i].Slot == slot {
+				row.Cells[plan.Entries[i].Day] = &plan.Entries[i]
+			}
+		}
+		page.Rows = append(page.Rows, row)
+	}
+
+	return page
+}
+
+// Database helper functions
+func getMealPlan(id int) (*MealPlan, error) {
+	var plan MealPlan
+	err := db.QueryRow("SELECT id, user_id, week_start FROM meal_plans WHERE id = ?", id).
+		Scan(&plan.ID, &plan.UserID, &plan.WeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.Entries, err = getMealPlanEntries(plan.ID, plan.WeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	var totalCost float64
+	for _, entry := range plan.Entries {
+		plan.TotalTimeMinutes += entry.Recipe.TimeMinutes
+		price, ok := parsePrice(entry.Recipe.Price)
+		if !ok || price < 0 {
+			plan.UnpricedEntries++
+			continue
+		}
+		totalCost += price
+	}
+	plan.TotalCost = fmt.Sprintf("%.2f", totalCost)
+
+	return &plan, nil
+}
+
+func getMealPlanEntries(planID int, weekStart string) ([]MealPlanEntry, error) {
+	rows, err := db.Query(`
+		SELECT e.id, e.day, e.slot, r.id, r.title, r.time_minutes, r.price, r.link
+		FROM meal_plan_entries e
+		JOIN recipes r ON r.id = e.recipe_id AND r.deleted_at IS NULL
+		WHERE e.meal_plan_id = ?
+		ORDER BY e.day, CASE e.slot WHEN 'breakfast' THEN 0 WHEN 'lunch' THEN 1 ELSE 2 END`, planID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	start, _ := time.Parse(dateLayout, weekStart)
+
+	entries := []MealPlanEntry{}
+	for rows.Next() {
+		var entry MealPlanEntry
+		var link sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Day, &entry.Slot, &entry.Recipe.ID, &entry.Recipe.Title,
+			&entry.Recipe.TimeMinutes, &entry.Recipe.Price, &link); err != nil {
+			return nil, err
+		}
+		entry.Recipe.Link = link.String
+		entry.Date = start.AddDate(0, 0, entry.Day).Format(dateLayout)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].Recipe.Tags, err = getTagsForRecipe(entries[i].Recipe.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+func deleteMealPlan(id int) error {
+	if _, err := db.Exec("DELETE FROM meal_plan_entries WHERE meal_plan_id = ?", id); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM meal_plans WHERE id = ?", id)
+	return err
+}
+
+// weekStartFor returns the Monday of the week containing day.
+func weekStartFor(day time.Time) time.Time {
+	offset := (int(day.Weekday()) + 6) % 7
+	return time.Date(day.Year(), day.Month(), day.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+func isMealPlanSlot(slot string) bool {
+	for _, s := range mealPlanSlots {
+		if s == slot {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrice reads a hand-entered price such as "12.50" or "$12.50". NaN
+// and infinity are not prices, callers check for negative ones.
+func parsePrice(price string) (float64, bool) {
+	price = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(price), "$"))
+	value, err := strconv.ParseFloat(price, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Nutrition structure, values are per 100 g unless noted otherwise
+type NutritionFacts struct {
+	Kcal    float64 `json:"kcal"`
+	Protein float64 `json:"protein"`
+	Fat     float64 `json:"fat"`
+	Carbs   float64 `json:"carbs"`
+}
+
+type RecipeNutrition struct {
+	Total       NutritionFacts          `json:"total"`
+	PerServing  *NutritionFacts         `json:"per_serving,omitempty"`
+	Unconverted []UnconvertedIngredient `json:"unconverted"`
+}
+
+// UnconvertedIngredient is a recipe ingredient left out of the totals,
+// together with the reason it could not be counted.
+type UnconvertedIngredient struct {
+	Ingredient
+	Reason string `json:"reason"`
+}
+
+type ingredientNutrition struct {
+	NutritionFacts
+	GramsPerML float64 `json:"grams_per_ml"`
+}
+
+// Conversion factors from recipe units to grams and millilitres. Counted
+// units like "pieces" or "cloves" have no fixed weight and are not listed.
+var (
+	unitGrams = map[string]float64{
+		"g":     1,
+		"gram":  1,
+		"kg":    1000,
+		"mg":    0.001,
+		"oz":    28.3495,
+		"ounce": 28.3495,
+		"lb":    453.592,
+		"pound": 453.592,
+	}
+
+	unitMilliliters = map[string]float64{
+		"ml":         1,
+		"l":          1000,
+		"liter":      1000,
+		"litre":      1000,
+		"tsp":        5,
+		"teaspoon":   5,
+		"tbsp":       15,
+		"tablespoon": 15,
+		"cup":        240,
+	}
+)
+
+// Handler functions
+func ingredientNutritionHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/ingredients/<id>/nutrition/")
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid ingredient ID", http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	err = db.QueryRow("SELECT name FROM ingredients WHERE id = ?", id).Scan(&name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Ingredient not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get ingredient", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		// Nothing to change, the nutrition is returned below
+
+	case http.MethodPut:
+		if _, ok := requireUser(w, r); !ok {
+			return
+		}
+		var facts ingredientNutrition
+		if err := json.NewDecoder(r.Body).Decode(&facts); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, value := range []float64{facts.Kcal, facts.Protein, facts.Fat, facts.Carbs, facts.GramsPerML} {
+			if !validAmount(value) {
+				http.Error(w, "Nutrition values must be zero or more", http.StatusBadRequest)
+				return
+			}
+		}
+		if facts.GramsPerML == 0 {
+			facts.GramsPerML = 1
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO ingredient_nutrition (ingredient_id, kcal, protein, fat, carbs, grams_per_ml) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (ingredient_id) DO UPDATE SET kcal = excluded.kcal, protein = excluded.protein, fat = excluded.fat,
+				carbs = excluded.carbs, grams_per_ml = excluded.grams_per_ml`,
+			id, facts.Kcal, facts.Protein, facts.Fat, facts.Carbs, facts.GramsPerML,
+		)
+		if err != nil {
+			log.Printf("Failed to set ingredient nutrition: %v", err)
+			http.Error(w, "Failed to update ingredient", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		if _, ok := requireUser(w, r); !ok {
+			return
+		}
+		if _, err := db.Exec("DELETE FROM ingredient_nutrition WHERE ingredient_id = ?", id); err != nil {
+			http.Error(w, "Failed to update ingredient", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	facts, err := getIngredientNutrition(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Ingredient has no nutrition", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get ingredient nutrition", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(facts)
+}
+
+// Database helper functions
+
+// getIngredientDensity returns the grams per millilitre of an ingredient,
+// 1 if it is not known.
+func getIngredientDensity(ingredientID int) (float64, error) {
+	var gramsPerML float64
+	err := db.QueryRow("SELECT grams_per_ml FROM ingredient_nutrition WHERE ingredient_id = ?", ingredientID).Scan(&gramsPerML)
+	if err == sql.ErrNoRows || (err == nil && gramsPerML <= 0) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return gramsPerML, nil
+}
+
+// getNutritionForRecipe totals the nutrition of the given recipe ingredients.
+// Ingredients that cannot be converted to grams or have no nutrition data are
+// reported in Unconverted instead of being counted.
+func getNutritionForRecipe(ingredients []Ingredient, servings int) (*RecipeNutrition, error) {
+	nutrition := &RecipeNutrition{Unconverted: []UnconvertedIngredient{}}
+
+	for _, ing := range ingredients {
+		facts, err := getIngredientNutrition(ing.ID)
+		if err == sql.ErrNoRows {
+			nutrition.Unconverted = append(nutrition.Unconverted, UnconvertedIngredient{ing, "no nutrition data"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		grams, err := amountInGrams(ing.Amount, ing.Unit, facts.GramsPerML)
+		if err != nil {
+			nutrition.Unconverted = append(nutrition.Unconverted, UnconvertedIngredient{ing, err.Error()})
+			continue
+		}
+
+		nutrition.Total.Kcal += facts.Kcal * grams / 100
+		nutrition.Total.Protein += facts.Protein * grams / 100
+		nutrition.Total.Fat += facts.Fat * grams / 100
+		nutrition.Total.Carbs += facts.Carbs * grams / 100
+	}
+
+	if servings > 0 {
+		nutrition.PerServing = &NutritionFacts{
+			Kcal:    roundNutrition(nutrition.Total.Kcal / float64(servings)),
+			Protein: roundNutrition(nutrition.Total.Protein / float64(servings)),
+			Fat:     roundNutrition(nutrition.Total.Fat / float64(servings)),
+			Carbs:   roundNutrition(nutrition.Total.Carbs / float64(servings)),
+		}
+	}
+
+	nutrition.Total = NutritionFacts{
+		Kcal:    roundNutrition(nutrition.Total.Kcal),
+		Protein: roundNutrition(nutrition.Total.Protein),
+		Fat:     roundNutrition(nutrition.Total.Fat),
+		Carbs:   roundNutrition(nutrition.Total.Carbs),
+	}
+
+	return nutrition, nil
+}
+
+func getIngredientNutrition(ingredientID int) (*ingredientNutrition, error) {
+	var facts ingredientNutrition
+	err := db.QueryRow("SELECT kcal, protein, fat, carbs, grams_per_ml FROM ingredient_nutrition WHERE ingredient_id = ?", ingredientID).
+		Scan(&facts.Kcal, &facts.Protein, &facts.Fat, &facts.Carbs, &facts.GramsPerML)
+	if err != nil {
+		return nil, err
+	}
+	return &facts, nil
+}
+
+// amountInGrams converts a recipe amount to grams. Volumes are converted with
+// the ingredient's density in grams per millilitre.
+func amountInGrams(amount, unit string, gramsPerML float64) (float64, error) {
+	quantity, err := parseAmount(amount)
+	if err != nil {
+		return 0, err
+	}
+	grams, err := convertAmount(quantity, unit, "g", gramsPerML)
+	if err != nil {
+		return 0, fmt.Errorf("unit %q cannot be converted to grams", unit)
+	}
+	return grams, nil
+}
+
+// convertAmount converts a quantity between units. Weights and volumes convert
+// into each other with the density in grams per millilitre; other units such
+// as "piece" only convert to themselves.
+func convertAmount(quantity float64, from, to string, gramsPerML float64) (float64, error) {
+	fromUnit, toUnit := normalizeUnit(from), normalizeUnit(to)
+
+	// Bring the quantity to grams or millilitres first
+	var grams, milliliters float64
+	if factor, ok := unitGrams[fromUnit]; ok {
+		grams = quantity * factor
+		milliliters = grams / gramsPerML
+	} else if factor, ok := unitMilliliters[fromUnit]; ok {
+		milliliters = quantity * factor
+		grams = milliliters * gramsPerML
+	} else if fromUnit == toUnit {
+		return quantity, nil
+	} else {
+		return 0, fmt.Errorf("unit %q cannot be converted to %s", from, to)
+	}
+
+	if factor, ok := unitGrams[toUnit]; ok {
+		return grams / factor, nil
+	}
+	if factor, ok := unitMilliliters[toUnit]; ok {
+		return milliliters / factor, nil
+	}
+	return 0, fmt.Errorf("unit %q cannot be converted to %s", from, to)
+}
+
+// normalizeUnit lower-cases a unit and strips plurals and abbreviation dots,
+// so "Tbsp." and "tablespoons" both become a key of the unit tables.
+func normalizeUnit(unit string) string {
+	unit = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(unit)), ".")
+	if _, ok := unitGrams[unit]; ok {
+		return unit
+	}
+	if _, ok := unitMilliliters[unit]; ok {
+		return unit
+	}
+	return strings.TrimSuffix(unit, "s")
+}
+
+// parseAmount reads amounts such as "400", "1.5", "1/2" and "1 1/2". Amounts
+// are never negative, and NaN and infinity, which strconv accepts, are not
+// numbers here.
+func parseAmount(amount string) (float64, error) {
+	fields := strings.Fields(amount)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("amount is missing")
+	}
+
+	total := 0.0
+	for _, field := range fields {
+		if numerator, denominator, ok := strings.Cut(field, "/"); ok {
+			n, err1 := strconv.ParseFloat(numerator, 64)
+			d, err2 := strconv.ParseFloat(denominator, 64)
+			if err1 != nil || err2 != nil || !validAmount(n) || !validAmount(d) || d == 0 {
+				return 0, fmt.Errorf("amount %q is not a number", amount)
+			}
+			total += n / d
+			continue
+		}
+
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil || !validAmount(value) {
+			return 0, fmt.Errorf("amount %q is not a number", amount)
+		}
+		total += value
+	}
+
+	if math.IsInf(total, 0) {
+		return 0, fmt.Errorf("amount %q is too large", amount)
+	}
+	return total, nil
+}
+
+// validAmount reports whether a parsed number is usable as an amount.
+func validAmount(value float64) bool {
+	return !math.IsNaN(value) && !math.IsInf(value, 0) && value >= 0
+}
+
+func roundNutrition(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+// seedNutrition fills in nutrition values for the sample ingredients that
+// exist in the database, matched by name.
+func seedNutrition() {
+	fmt.Println("Seeding nutrition data...")
+
+	nutrition := map[string]ingredientNutrition{
+		"Spaghetti":         {NutritionFacts{371, 13, 1.5, 75}, 1},
+		"Eggs":              {NutritionFacts{143, 12.6, 9.5, 0.7}, 1},
+		"Pancetta":          {NutritionFacts{458, 14.5, 44, 0}, 1},
+		"Parmesan Cheese":   {NutritionFacts{431, 38, 29, 4.1}, 1},
+		"Black Pepper":      {NutritionFacts{251, 10.4, 3.3, 64}, 0.46},
+		"Salt":              {NutritionFacts{0, 0, 0, 0}, 1.2},
+		"Chicken Breast":    {NutritionFacts{165, 31, 3.6, 0}, 1},
+		"Breadcrumbs":       {NutritionFacts{395, 13.4, 5.3, 72}, 0.45},
+		"Mozzarella Cheese": {NutritionFacts{300, 22, 22, 2.2}, 1},
+		"Tomato Sauce":      {NutritionFacts{24, 1.2, 0.3, 5.3}, 1.03},
+		"Olive Oil":         {NutritionFacts{884, 0, 100, 0}, 0.91},
+		"Garlic":            {NutritionFacts{149, 6.4, 0.5, 33}, 1},
+		"Penne Pasta":       {NutritionFacts{371, 13, 1.5, 75}, 1},
+		"Bell Peppers":      {NutritionFacts{31, 1, 0.3, 6}, 1},
+		"Zucchini":          {NutritionFacts{17, 1.2, 0.3, 3.1}, 1},
+		"Cherry Tomatoes":   {NutritionFacts{18, 0.9, 0.2, 3.9}, 1},
+		"Basil":             {NutritionFacts{23, 3.2, 0.6, 2.7}, 0.1},
+		"Butter":            {NutritionFacts{717, 0.9, 81, 0.1}, 0.96},
+		"Flour":             {NutritionFacts{364, 10, 1, 76}, 0.53},
+		"Salmon Fillet":     {NutritionFacts{208, 20, 13, 0}, 1},
+		"Lemon":             {NutritionFacts{29, 1.1, 0.3, 9.3}, 1},
+		"Dill":              {NutritionFacts{43, 3.5, 1.1, 7}, 0.07},
+	}
+
+	for name, facts := range nutrition {
+		_, err := db.Exec(`
+			INSERT INTO ingredient_nutrition (ingredient_id, kcal, protein, fat, carbs, grams_per_ml)
+			SELECT id, ?, ?, ?, ?, ? FROM ingredients WHERE name = ?`,
+			facts.Kcal, facts.Protein, facts.Fat, facts.Carbs, facts.GramsPerML, name,
+		)
+		if err != nil {
+			log.Printf("Failed to insert nutrition for %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestNormalizeUnit(t *testing.T) {
+	tests := []struct {
+		unit string
+		want string
+	}{
+		{"g", "g"},
+		{"Kg", "kg"},
+		{" ML ", "ml"},
+		{"Tbsp.", "tbsp"},
+		{"tablespoons", "tablespoon"},
+		{"cups", "cup"},
+		{"cloves", "clove"},
+		{"piece", "piece"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeUnit(tt.unit); got != tt.want {
+			t.Errorf("normalizeUnit(%q) = %q, want %q", tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   float64
+		ok     bool
+	}{
+		{"400", 400, true},
+		{"1.5", 1.5, true},
+		{"1/2", 0.5, true},
+		{"1 1/2", 1.5, true},
+		{" 2 ", 2, true},
+		{"0", 0, true},
+		{"", 0, false},
+		{"a pinch", 0, false},
+		{"1/0", 0, false},
+		{"-1", 0, false},
+		{"1/-2", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"1e999", 0, false},
+		{"1e308 1e308", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.amount)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseAmount(%q) = %v, %v, want %v, ok %v", tt.amount, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Page sizes in points (1/72 inch). Index cards are 6x4 inch, landscape.
+type pageSize struct {
+	Name          string
+	Width, Height float64
+	FontSize      float64
+	Margin        float64
+}
+
+var pageSizes = map[string]pageSize{
+	"a5":   {Name: "a5", Width: 419.53, Height: 595.28, FontSize: 10, Margin: 36},
+	"card": {Name: "card", Width: 432, Height: 288, FontSize: 8, Margin: 18},
+}
+
+// Fonts are the standard Helvetica fonts every PDF reader has, so nothing is
+// embedded. Text is written in WinAnsiEncoding.
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// Glyph widths of Helvetica and Helvetica-Bold for the printable ASCII
+// characters, in 1/1000 of the font size. Other characters use the width of
+// a digit, which is close enough for line wrapping.
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// WinAnsiEncoding matches Latin-1 except for 0x80-0x9F, which hold typographic
+// characters that show up in recipes copied from the web.
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfWriter builds a PDF document page by page. Coordinates are in points
+// from the top left corner of the page.
+type pdfWriter struct {
+	width, height float64
+	pages         []*bytes.Buffer
+}
+
+// Handler functions
+func recipeCardsHandler(w http.ResponseWriter, r *http.Request, idParams []string) {
+	fmt.Println("Route invoked: GET /recipes/<id>/card.pdf")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sizeName := r.URL.Query().Get("size")
+	if sizeName == "" {
+		sizeName = "a5"
+	}
+	size, ok := pageSizes[sizeName]
+	if !ok {
+		http.Error(w, "Invalid size, expected a5 or card", http.StatusBadRequest)
+		return
+	}
+
+	// Without IDs every recipe gets a card
+	if len(idParams) == 0 {
+		rows, err := db.Query("SELECT id FROM recipes WHERE deleted_at IS NULL ORDER BY title COLLATE NOCASE")
+		if err != nil {
+			http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
+			return
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				http.Error(w, "Failed to get recipes", http.StatusInternalServerError)
+				return
+			}
+			idParams = append(idParams, id)
+		}
+		rows.Close()
+	}
+
+	var recipes []*Recipe
+	for _, idParam := range idParams {
+		id, err := strconv.Atoi(strings.TrimSpace(idParam))
+		if err != nil {
+			http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+			return
+		}
+		recipe, err := getRecipeByID(id)
+		if err == sql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("Recipe %d not found", id), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		recipes = append(recipes, recipe)
+	}
+
+	filename := "recipes.pdf"
+	if len(recipes) == 1 {
+		filename = fmt.Sprintf("recipe-%d.pdf", recipes[0].ID)
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="`+filename+`"`)
+	if err := writeRecipeCards(w, recipes, size); err != nil {
+		log.Printf("Failed to write recipe cards: %v", err)
+	}
+}
+
+// writeRecipeCards writes a PDF with one card per recipe. Recipes that don't
+// fit on one page continue on the next.
+func writeRecipeCards(w io.Writer, recipes []*Recipe, size pageSize) error {
+	pdf := &pdfWriter{width: size.Width, height: size.Height}
+	fontSize, margin := size.FontSize, size.Margin
+	lineHeight := fontSize * 1.3
+	textWidth := size.Width - 2*margin
+
+	for _, recipe := range recipes {
+		y := margin
+		pdf.addPage()
+
+		// newLine moves down one line of the given height, starting a new
+		// page headed by the recipe title when the current one is full.
+		newLine := func(height float64) {
+			y += height
+			if y > size.Height-margin {
+				pdf.addPage()
+				y = margin + fontSize
+				pdf.text(margin, y, fontBold, fontSize, recipe.Title+" (continued)")
+				y += lineHeight * 1.5
+			}
+		}
+
+		titleSize := fontSize * 1.6
+		for i, line := range wrapPDFText(recipe.Title, fontBold, titleSize, textWidth) {
+			if i > 0 {
+				y += titleSize * 1.2
+			}
+			pdf.text(margin, y+titleSize, fontBold, titleSize, line)
+		}
+		y += titleSize * 1.2
+
+		details := []string{fmt.Sprintf("%d minutes", recipe.TimeMinutes)}
+		if recipe.Price != "" {
+			details = append(details, "$"+recipe.Price)
+		}
+		if recipe.Servings > 0 {
+			details = append(details, fmt.Sprintf("serves %d", recipe.Servings))
+		}
+		newLine(lineHeight)
+		pdf.text(margin, y, fontRegular, fontSize, strings.Join(details, "  ·  "))
+		y += lineHeight / 2
+		pdf.line(margin, y, size.Width-margin, y)
+		y += lineHeight / 2
+
+		if len(recipe.Ingredients) > 0 {
+			newLine(lineHeight * 1.2)
+			pdf.text(margin, y, fontBold, fontSize*1.1, "Ingredients")
+			for _, ing := range recipe.Ingredients {
+				for i, line := range wrapPDFText(ingredientLine(ing), fontRegular, fontSize, textWidth-fontSize) {
+					newLine(lineHeight)
+					if i == 0 {
+						pdf.text(margin, y, fontRegular, fontSize, "•")
+					}
+					pdf.text(margin+fontSize, y, fontRegular, fontSize, line)
+				}
+			}
+			y += lineHeight / 2
+		}
+
+		if steps := recipeSteps(recipe.Description); len(steps) > 0 {
+			newLine(lineHeight * 1.2)
+			pdf.text(margin, y, fontBold, fontSize*1.1, "Steps")
+			indent := fontSize * 1.6
+			for n, step := range steps {
+				for i, line := range wrapPDFText(step, fontRegular, fontSize, textWidth-indent) {
+					newLine(lineHeight)
+					if i == 0 {
+						pdf.text(margin, y, fontBold, fontSize, strconv.Itoa(n+1)+".")
+					}
+					pdf.text(margin+indent, y, fontRegular, fontSize, line)
+				}
+				y += lineHeight / 4
+			}
+		}
+	}
+
+	if len(pdf.pages) == 0 {
+		pdf.addPage()
+	}
+	return pdf.writeTo(w)
+}
+
+// wrapPDFText breaks text into lines no wider than width. Words longer than a
+// line are left to overflow.
+func wrapPDFText(text, font string, fontSize, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && pdfTextWidth(candidate, font, fontSize) > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func pdfTextWidth(text, font string, fontSize float64) float64 {
+	widths := &helveticaWidths
+	if font == fontBold {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, r := range text {
+		if r >= 32 && r <= 126 {
+			total += widths[r-32]
+		} else {
+			total += widths['0'-32]
+		}
+	}
+	return float64(total) * fontSize / 1000
+}
+
+func (p *pdfWriter) addPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+}
+
+// text draws a line of text with its baseline at y.
+func (p *pdfWriter) text(x, y float64, font string, fontSize float64, s string) {
+	fmt.Fprintf(p.pages[len(p.pages)-1], "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font, fontSize, x, p.height-y, pdfString(s))
+}
+
+func (p *pdfWriter) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(p.pages[len(p.pages)-1], "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, p.height-y1, x2, p.height-y2)
+}
+
+// writeTo writes the document. Objects 1 and 2 are the catalog and the page
+// tree, 3 and 4 the fonts, followed by a page and a content stream per page.
+func (p *pdfWriter) writeTo(w io.Writer) error {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(format string, args ...any) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(&buf, format, args...)
+		buf.WriteString("\nendobj\n")
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	var kids []string
+	for i := range p.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range p.pages {
+		object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			p.width, p.height, 6+2*i)
+		object("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfString encodes text for a PDF string literal in WinAnsiEncoding.
+// Characters the encoding lacks are replaced by a question mark.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r <= 126:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsiExtra[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsiExtra[r])
+		case r == utf8.RuneError || r < 32:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Number of empty ingredient rows offered below the filled in ones
+const blankIngredientRows = 2
+
+// recipeForm is the recipe create and edit form. Values are kept as they were
+// entered so an invalid form is shown again unchanged, with Errors keyed by
+// field name and RowErrors by ingredient row.
+type recipeForm struct {
+	ID          int
+	Title       string
+	TimeMinutes string
+	Price       string
+	Servings    string
+	Link        string
+	Description string
+	Ingredients []Ingredient
+	TagIDs      []int
+	NewTags     string
+	GroupID     int
+
+	Errors    map[string]string
+	RowErrors map[int]string
+
+	// Choices offered by the form
+	AllTags         []Tag
+	IngredientNames []string
+	Groups          []Group
+}
+
+// Handler functions
+func recipeFormHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if id == 0 {
+		fmt.Println("Route invoked: " + r.Method + " /recipes/new/")
+	} else {
+		fmt.Println("Route invoked: " + r.Method + " /recipes/<id>/edit/")
+	}
+
+	userID, ok := requirePageUser(w, r)
+	if !ok {
+		return
+	}
+	// Group recipes are only edited by the group's editors and owners
+	if id != 0 && !requireRecipeWritePage(w, r, id) {
+		return
+	}
+
+	var form *recipeForm
+	switch r.Method {
+	case http.MethodGet:
+		form = &recipeForm{}
+		if id != 0 {
+			recipe, err := getRecipeByID(id)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Recipe not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+				return
+			}
+			form = newRecipeForm(recipe)
+		}
+
+	case http.MethodPost:
+		if !validCSRF(r) {
+			http.Error(w, "Invalid or missing CSRF token, reload the form and try again", http.StatusForbidden)
+			return
+		}
+		form = parseRecipeForm(r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	form.ID = id
+
+	if err := form.loadChoices(userID); err != nil {
+		http.Error(w, "Failed to load the form", http.StatusInternalServerError)
+		return
+	}
+
+	// Without JavaScript more ingredient rows are added by posting the form
+	// with the "add_row" action, which doesn't save anything
+	if r.Method == http.MethodGet || r.PostFormValue("action") == "add_row" {
+		form.addBlankRows()
+		renderPage(w, r, "recipe_form.html", form)
+		return
+	}
+
+	recipe := form.recipe()
+	if len(form.Errors) > 0 || len(form.RowErrors) > 0 {
+		form.addBlankRows()
+		renderPageStatus(w, r, http.StatusUnprocessableEntity, "recipe_form.html", form)
+		return
+	}
+
+	// Taking a recipe out of its group is up to the group's owners
+	var err error
+	from := 0
+	if id != 0 {
+		if from, err = getRecipeGroupID(id); err != nil && err != sql.ErrNoRows {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := checkGroupChange(userID, from, recipe.GroupID); err == errForbidden || err == errNotAuthenticated {
+		form.Errors["group_id"] = "Only owners of the group can take the recipe out of it."
+		form.addBlankRows()
+		renderPageStatus(w, r, http.StatusUnprocessableEntity, "recipe_form.html", form)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+
+	if id == 0 {
+		id, err = createRecipe(recipe)
+	} else {
+		err = updateRecipe(id, recipe)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save recipe: %v", err)
+		http.Error(w, "Failed to save recipe", http.StatusInternalServerError)
+		return
+	}
+
+	setFlash(w, fmt.Sprintf("Saved %q.", recipe.Title))
+	http.Redirect(w, r, fmt.Sprintf("/recipes/%d/", id), http.StatusSeeOther)
+}
+
+func recipeDeleteHandler(w http.ResponseWriter, r *http.Request, id int) {
+	fmt.Println("Route invoked: " + r.Method + " /recipes/<id>/delete/")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := requirePageUser(w, r); !ok {
+		return
+	}
+	if !requireRecipeWritePage(w, r, id) {
+		return
+	}
+
+	recipe, err := getRecipeByID(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		renderPage(w, r, "recipe_delete.html", recipe)
+		return
+	}
+
+	if !validCSRF(r) {
+		http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+	if err := trashRecipe(id); err != nil {
+		log.Printf("Failed to delete recipe: %v", err)
+		http.Error(w, "Failed to delete recipe", http.StatusInternalServerError)
+		return
+	}
+
+	setFlash(w, fmt.Sprintf("Moved %q to the trash.", recipe.Title))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// newRecipeForm fills in the form with a stored recipe.
+func newRecipeForm(recipe *Recipe) *recipeForm {
+	form := &recipeForm{
+		Title:       recipe.Title,
+		TimeMinutes: strconv.Itoa(recipe.TimeMinutes),
+		Price:       recipe.Price,
+		Link:        recipe.Link,
+		Description: recipe.Description,
+		Ingredients: recipe.Ingredients,
+		GroupID:     recipe.GroupID,
+	}
+	if recipe.Servings > 0 {
+		form.Servings = strconv.Itoa(recipe.Servings)
+	}
+	for _, tag := range recipe.Tags {
+		form.TagIDs = append(form.TagIDs, tag.ID)
+	}
+	return form
+}
+
+// parseRecipeForm reads a posted form. Ingredient rows are the nth values of
+// the ingredient_amount, ingredient_unit and ingredient_name fields, rows
+// left completely empty are dropped.
+func parseRecipeForm(r *http.Request) *recipeForm {
+	form := &recipeForm{
+		Title:       strings.TrimSpace(r.PostFormValue("title")),
+		TimeMinutes: strings.TrimSpace(r.PostFormValue("time_minutes")),
+		Price:       strings.TrimSpace(r.PostFormValue("price")),
+		Servings:    strings.TrimSpace(r.PostFormValue("servings")),
+		Link:        strings.TrimSpace(r.PostFormValue("link")),
+		Description: strings.TrimSpace(strings.ReplaceAll(r.PostFormValue("description"), "\r\n", "\n")),
+		NewTags:     strings.TrimSpace(r.PostFormValue("new_tags")),
+	}
+	if id, err := strconv.Atoi(r.PostFormValue("group_id")); err == nil {
+		form.GroupID = id
+	}
+
+	amounts, units, names := r.PostForm["ingredient_amount"], r.PostForm["ingredient_unit"], r.PostForm["ingredient_name"]
+	value := func(values []string, i int) string {
+		if i < len(values) {
+			return strings.TrimSpace(values[i])
+		}
+		return ""
+	}
+	for i := 0; i < max(len(amounts), len(units), len(names)); i++ {
+		ing := Ingredient{Amount: value(amounts, i), Unit: value(units, i), Name: value(names, i)}
+		if ing.Amount != "" || ing.Unit != "" || ing.Name != "" {
+			form.Ingredients = append(form.Ingredients, ing)
+		}
+	}
+
+	for _, param := range r.PostForm["tag"] {
+		if id, err := strconv.Atoi(param); err == nil && !slices.Contains(form.TagIDs, id) {
+			form.TagIDs = append(form.TagIDs, id)
+		}
+	}
+
+	return form
+}
+
+// recipe validates the form and returns the recipe it describes. Problems
+// are recorded in Errors and RowErrors.
+func (f *recipeForm) recipe() *Recipe {
+	f.Errors = map[string]string{}
+	f.RowErrors = map[int]string{}
+	recipe := &Recipe{
+		Title:       f.Title,
+		Price:       f.Price,
+		Link:        f.Link,
+		Description: f.Description,
+	}
+
+	if f.Title == "" {
+		f.Errors["title"] = "Enter a title."
+	} else if len(f.Title) > 200 {
+		f.Errors["title"] = "Keep the title under 200 characters."
+	}
+
+	minutes, err := strconv.Atoi(f.TimeMinutes)
+	if err != nil || minutes <= 0 {
+		f.Errors["time_minutes"] = "Enter the cooking time as a whole number of minutes, like 45."
+	}
+	recipe.TimeMinutes = minutes
+
+	if price, ok := parsePrice(f.Price); !ok || price < 0 {
+		f.Errors["price"] = "Enter the price as a number, like 12.50."
+	} else {
+		recipe.Price = fmt.Sprintf("%.2f", price)
+	}
+
+	if f.Servings != "" {
+		servings, err := strconv.Atoi(f.Servings)
+		if err != nil || servings <= 0 {
+			f.Errors["servings"] = "Enter the number of servings as a whole number, or leave it empty."
+		}
+		recipe.Servings = servings
+	}
+
+	if f.Link != "" {
+		if u, err := url.Parse(f.Link); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			f.Errors["link"] = "Enter a web address starting with http:// or https://, or leave it empty."
+		}
+	}
+
+	for i, ing := range f.Ingredients {
+		if ing.Name == "" {
+			f.RowErrors[i] = "Enter the name of the ingredient."
+			continue
+		}
+		if ing.Amount != "" {
+			if _, err := parseAmount(ing.Amount); err != nil {
+				f.RowErrors[i] = "Enter the amount as a number or fraction, like 200 or 1/2."
+				continue
+			}
+		}
+		recipe.Ingredients = append(recipe.Ingredients, ing)
+	}
+
+	for _, id := range f.TagIDs {
+		if !slices.ContainsFunc(f.AllTags, func(tag Tag) bool { return tag.ID == id }) {
+			f.Errors["tags"] = "One of the selected tags no longer exists."
+			continue
+		}
+		recipe.Tags = append(recipe.Tags, Tag{ID: id})
+	}
+
+	// The form offers the groups the user can add recipes to, and the one the
+	// recipe is in
+	if f.GroupID != 0 {
+		if !slices.ContainsFunc(f.Groups, func(group Group) bool { return group.ID == f.GroupID }) {
+			f.Errors["group_id"] = "Choose one of your groups in which you are an editor or owner."
+		}
+		recipe.GroupID = f.GroupID
+	}
+
+	// New tags that already exist are used as if they were selected
+	for _, name := range strings.Split(f.NewTags, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		i := slices.IndexFunc(f.AllTags, func(tag Tag) bool { return strings.EqualFold(tag.Name, name) })
+		if i >= 0 && slices.Contains(f.TagIDs, f.AllTags[i].ID) {
+			continue
+		}
+		if i >= 0 {
+			recipe.Tags = append(recipe.Tags, f.AllTags[i])
+		} else if !slices.ContainsFunc(recipe.Tags, func(tag Tag) bool { return strings.EqualFold(tag.Name, name) }) {
+			recipe.Tags = append(recipe.Tags, Tag{Name: name})
+		}
+	}
+
+	return recipe
+}
+
+// loadChoices reads the tags, ingredient names and groups the form offers to
+// the user, 0 if nobody is signed in.
+func (f *recipeForm) loadChoices(userID int) error {
+	rows, err := db.Query("SELECT id, name FROM tags ORDER BY name COLLATE NOCASE")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+			return err
+		}
+		f.AllTags = append(f.AllTags, tag)
+	}
+
+	rows, err = db.Query("SELECT name FROM ingredients ORDER BY name COLLATE NOCASE")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		f.IngredientNames = append(f.IngredientNames, name)
+	}
+
+	if userID != 0 {
+		groups, err := getGroups(userID, "editor")
+		if err != nil {
+			return err
+		}
+		f.Groups = groups
+	}
+
+	return nil
+}
+
+func (f *recipeForm) addBlankRows() {
+	for range blankIngredientRows {
+		f.Ingredients = append(f.Ingredients, Ingredient{})
+	}
+}
+
+// HasTag reports whether the tag is selected.
+func (f *recipeForm) HasTag(id int) bool {
+	return slices.Contains(f.TagIDs, id)
+}
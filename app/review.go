@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Longest review text accepted
+const maxReviewLength = 5000
+
+// Review is the star rating of a user for a recipe, with an optional text.
+// Every user has at most one review per recipe.
+type Review struct {
+	ID        int    `json:"id"`
+	RecipeID  int    `json:"recipe_id"`
+	UserID    int    `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Rating    int    `json:"rating"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type ReviewRequest struct {
+	Rating int    `json:"rating"`
+	Text   string `json:"text"`
+}
+
+// Handler functions
+func recipeReviewsHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/recipes/<id>/reviews/")
+
+	recipeID, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := getRecipeTitle(recipeID); err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		reviews, err := getReviewsForRecipe(recipeID)
+		if err != nil {
+			http.Error(w, "Failed to get reviews", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reviews)
+
+	case http.MethodPost:
+		userID, ok := requireUser(w, r)
+		if !ok {
+			return
+		}
+
+		var req ReviewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := validateReview(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		created, err := saveReview(recipeID, userID, req)
+		if err != nil {
+			log.Printf("Failed to save review: %v", err)
+			http.Error(w, "Failed to save review", http.StatusInternalServerError)
+			return
+		}
+		review, err := getReview(recipeID, userID)
+		if err != nil {
+			http.Error(w, "Failed to get review", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(review)
+
+	case http.MethodDelete:
+		userID, ok := requireUser(w, r)
+		if !ok {
+			return
+		}
+
+		err := deleteReview(recipeID, userID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Review not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete review", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// reviewFormHandler saves or deletes the review of the signed in user from
+// the form on the recipe page, and goes back to the page.
+func reviewFormHandler(w http.ResponseWriter, r *http.Request, recipeID int) {
+	fmt.Println("Route invoked: POST /recipes/<id>/reviews/")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+	userID, ok := requirePageUser(w, r)
+	if !ok {
+		return
+	}
+	if _, err := getRecipeTitle(recipeID); err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	back := fmt.Sprintf("/recipes/%d/#reviews", recipeID)
+	if r.PostFormValue("action") == "delete" {
+		if err := deleteReview(recipeID, userID); err != nil && err != sql.ErrNoRows {
+			http.Error(w, "Failed to delete review", http.StatusInternalServerError)
+			return
+		}
+		setFlash(w, "Your review was removed.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	req := ReviewRequest{Text: r.PostFormValue("text")}
+	req.Rating, _ = strconv.Atoi(r.PostFormValue("rating"))
+	if err := validateReview(&req); err != nil {
+		setFlash(w, "Your review was not saved: "+err.Error()+".")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if _, err := saveReview(recipeID, userID, req); err != nil {
+		log.Printf("Failed to save review: %v", err)
+		http.Error(w, "Failed to save review", http.StatusInternalServerError)
+		return
+	}
+	setFlash(w, "Thanks, your review was saved.")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// validateReview checks the rating and tidies up the text.
+func validateReview(req *ReviewRequest) error {
+	if req.Rating < 1 || req.Rating > 5 {
+		return fmt.Errorf("rating must be between 1 and 5 stars")
+	}
+	req.Text = strings.TrimSpace(strings.ReplaceAll(req.Text, "\r\n", "\n"))
+	if len(req.Text) > maxReviewLength {
+		return fmt.Errorf("review text must be at most %d characters", maxReviewLength)
+	}
+	return nil
+}
+
+// stars shows a rating, a whole or average number of stars, as five filled
+// or empty stars.
+func stars(rating any) string {
+	var value float64
+	switch rating := rating.(type) {
+	case int:
+		value = float64(rating)
+	case float64:
+		value = rating
+	}
+	filled := max(0, min(5, int(math.Round(value))))
+	return strings.Repeat("★", filled) + strings.Repeat("☆", 5-filled)
+}
+
+// Database helper functions
+func getRecipeTitle(id int) (string, error) {
+	var title string
+	err := db.QueryRow("SELECT title FROM recipes WHERE id = ? AND deleted_at IS NULL", id).Scan(&title)
+	return title, err
+}
+
+func getReviewsForRecipe(recipeID int) ([]Review, error) {
+	rows, err := db.Query(`
+		SELECT rv.id, rv.recipe_id, rv.user_id, u.name, rv.rating, rv.text, rv.created_at, rv.updated_at
+		FROM recipe_reviews rv
+		JOIN users u ON u.id = rv.user_id
+		WHERE rv.recipe_id = ?
+		ORDER BY rv.updated_at DESC, rv.id DESC`, recipeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		var review Review
+		if err := rows.Scan(&review.ID, &review.RecipeID, &review.UserID, &review.UserName, &review.Rating, &review.Text, &review.CreatedAt, &review.UpdatedAt); err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+func getReview(recipeID, userID int) (*Review, error) {
+	var review Review
+	err := db.QueryRow(`
+		SELECT rv.id, rv.recipe_id, rv.user_id, u.name, rv.rating, rv.text, rv.created_at, rv.updated_at
+		FROM recipe_reviews rv
+		JOIN users u ON u.id = rv.user_id
+		WHERE rv.recipe_id = ? AND rv.user_id = ?`, recipeID, userID).
+		Scan(&review.ID, &review.RecipeID, &review.UserID, &review.UserName, &review.Rating, &review.Text, &review.CreatedAt, &review.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// saveReview adds the user's review of the recipe or replaces the one they
+// wrote before, reporting whether it is new.
+func saveReview(recipeID, userID int, req ReviewRequest) (bool, error) {
+	now := time.Now().UTC().Format(sqliteTime)
+	result, err := db.Exec(
+		"UPDATE recipe_reviews SET rating = ?, text = ?, updated_at = ? WHERE recipe_id = ? AND user_id = ?",
+		req.Rating, req.Text, now, recipeID, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return false, err
+	}
+
+	_, err = db.Exec(
+		"INSERT INTO recipe_reviews (recipe_id, user_id, rating, text, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		recipeID, userID, req.Rating, req.Text, now, now,
+	)
+	return err == nil, err
+}
+
+func deleteReview(recipeID, userID int) error {
+	result, err := db.Exec("DELETE FROM recipe_reviews WHERE recipe_id = ? AND user_id = ?", recipeID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// getRatingForRecipe returns the average rating of a recipe, rounded to one
+// decimal, and the number of ratings. The average is 0 without ratings.
+func getRatingForRecipe(recipeID int) (float64, int, error) {
+	var average sql.NullFloat64
+	var count int
+	err := db.QueryRow("SELECT AVG(rating), COUNT(*) FROM recipe_reviews WHERE recipe_id = ?", recipeID).Scan(&average, &count)
+	if err != nil {
+		return 0, 0, err
+	}
+	return math.Round(average.Float64*10) / 10, count, nil
+}
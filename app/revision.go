@@ -0,0 +1,438 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Lines of unchanged text shown around each change of a revision diff
+const diffContextLines = 3
+
+// RecipeRevision is a snapshot of a recipe as it was saved. A revision is
+// recorded whenever a recipe is created or changed and revisions are never
+// updated, so together they are the history of the recipe. Revisions are
+// numbered from 1 for each recipe.
+type RecipeRevision struct {
+	RecipeID  int            `json:"recipe_id"`
+	Number    int            `json:"number"`
+	CreatedAt string         `json:"created_at"`
+	Recipe    RecipeSnapshot `json:"recipe"`
+}
+
+// RecipeSnapshot holds the stored fields of a recipe, without the values
+// computed from them.
+type RecipeSnapshot struct {
+	Title       string       `json:"title"`
+	TimeMinutes int          `json:"time_minutes"`
+	Price       string       `json:"price"`
+	Link        string       `json:"link"`
+	Description string       `json:"description"`
+	Servings    int          `json:"servings"`
+	GroupID     int          `json:"group_id,omitempty"`
+	Group       string       `json:"group,omitempty"`
+	Ingredients []Ingredient `json:"ingredients"`
+	Tags        []Tag        `json:"tags"`
+}
+
+// Handler functions
+func recipeRevisionsHandler(w http.ResponseWriter, r *http.Request, idParam string, segments []string) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/recipes/<id>/revisions/")
+
+	recipeID, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := getRecipeTitle(recipeID); err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	if len(segments) == 0 {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		revisions, err := getRecipeRevisions(recipeID)
+		if err != nil {
+			http.Error(w, "Failed to get revisions", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revisions)
+		return
+	}
+
+	number, err := strconv.Atoi(segments[0])
+	if err != nil {
+		http.Error(w, "Invalid revision number", http.StatusBadRequest)
+		return
+	}
+	revision, err := getRecipeRevision(recipeID, number)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get revision", http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revision)
+
+	case len(segments) == 2 && segments[1] == "diff" && r.Method == http.MethodGet:
+		revisionDiffHandler(w, r, revision)
+
+	case len(segments) == 2 && segments[1] == "restore" && r.Method == http.MethodPost:
+		if _, ok := requireUser(w, r); !ok {
+			return
+		}
+		if !requireRecipeWrite(w, r, recipeID) {
+			return
+		}
+		// Restoring keeps the recipe in its current group, moving it is
+		// up to the group's owners
+		restored := revision.Recipe.recipe()
+		if restored.GroupID, err = getRecipeGroupID(recipeID); err != nil {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		if err := updateRecipe(recipeID, restored); err != nil {
+			log.Printf("Failed to restore revision: %v", err)
+			http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+			return
+		}
+		recipe, err := getRecipeByID(recipeID)
+		if err != nil {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(recipe)
+
+	case len(segments) == 2 && (segments[1] == "diff" || segments[1] == "restore"):
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// revisionDiffHandler writes the changes from the revision given by the from
+// parameter, by default the one before, to the revision as a unified diff of
+// the plain text versions of the recipe.
+func revisionDiffHandler(w http.ResponseWriter, r *http.Request, revision *RecipeRevision) {
+	from := &RecipeRevision{RecipeID: revision.RecipeID}
+	fromNumber := revision.Number - 1
+	if param := r.URL.Query().Get("from"); param != "" {
+		var err error
+		if fromNumber, err = strconv.Atoi(param); err != nil || fromNumber < 1 {
+			http.Error(w, "Invalid from revision number", http.StatusBadRequest)
+			return
+		}
+	}
+	if fromNumber > 0 {
+		var err error
+		from, err = getRecipeRevision(revision.RecipeID, fromNumber)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Revision to compare with not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to get revision", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writeUnifiedDiff(w, from.label(), revision.label(), from.text(), revision.text())
+}
+
+// recipe returns the snapshot as a recipe to save. Ingredients and tags are
+// matched by name, so restoring works after they were deleted.
+func (s RecipeSnapshot) recipe() *Recipe {
+	recipe := &Recipe{
+		Title:       s.Title,
+		TimeMinutes: s.TimeMinutes,
+		Price:       s.Price,
+		Link:        s.Link,
+		Description: s.Description,
+		Servings:    s.Servings,
+	}
+	for _, ing := range s.Ingredients {
+		recipe.Ingredients = append(recipe.Ingredients, Ingredient{Name: ing.Name, Amount: ing.Amount, Unit: ing.Unit})
+	}
+	for _, tag := range s.Tags {
+		recipe.Tags = append(recipe.Tags, Tag{Name: tag.Name})
+	}
+	return recipe
+}
+
+// label names the revision in the header of a diff.
+func (rev *RecipeRevision) label() string {
+	if rev.Number == 0 {
+		return "/dev/null"
+	}
+	return fmt.Sprintf("recipe %d revision %d\t%s", rev.RecipeID, rev.Number, rev.CreatedAt)
+}
+
+// text returns the lines of the plain text version of the revision, none for
+// the empty revision before the first one.
+func (rev *RecipeRevision) text() []string {
+	if rev.Number == 0 {
+		return nil
+	}
+	var b strings.Builder
+	writeRecipeText(&b, rev.Recipe.recipe())
+	if rev.Recipe.GroupID != 0 {
+		fmt.Fprintf(&b, "Group: %s\n", rev.Recipe.Group)
+	}
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+// writeUnifiedDiff writes the differences between two texts in the unified
+// format of diff -u, nothing if they are the same.
+func writeUnifiedDiff(w io.Writer, fromLabel, toLabel string, a, b []string) {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// Edit script, with the line numbers each line has in a and b
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	headerWritten := false
+	for start := 0; start < len(edits); {
+		// Find the next change and the end of its hunk, which takes in
+		// changes less than two contexts apart
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for k := first; k < len(edits) && k-last <= 2*diffContextLines; k++ {
+			if edits[k].op != ' ' {
+				last = k
+			}
+		}
+		from := max(start, first-diffContextLines)
+		to := min(len(edits), last+diffContextLines+1)
+
+		if !headerWritten {
+			fmt.Fprintf(w, "--- %s\n+++ %s\n", fromLabel, toLabel)
+			headerWritten = true
+		}
+		var aCount, bCount int
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(w, "@@ -%s +%s @@\n", diffRange(edits[from].i, aCount), diffRange(edits[from].j, bCount))
+		for _, e := range edits[from:to] {
+			fmt.Fprintf(w, "%c%s\n", e.op, e.line)
+		}
+		start = to
+	}
+}
+
+// diffRange formats the line range of a hunk, counting lines from 1. An empty
+// range is given by the line before it.
+func diffRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return strconv.Itoa(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// Database helper functions
+
+// recordRevision saves the recipe as it is within the transaction as its next
+// revision. Tags are sorted by name so reordering them isn't a change.
+func recordRevision(tx *sql.Tx, recipeID int) error {
+	var snapshot RecipeSnapshot
+	err := tx.QueryRow(`
+		SELECT r.title, r.time_minutes, r.price, r.link, r.description, COALESCE(r.servings, 0), COALESCE(g.id, 0), COALESCE(g.name, '')
+		FROM recipes r
+		LEFT JOIN groups g ON g.id = r.group_id
+		WHERE r.id = ?`, recipeID).
+		Scan(&snapshot.Title, &snapshot.TimeMinutes, &snapshot.Price, &snapshot.Link, &snapshot.Description, &snapshot.Servings, &snapshot.GroupID, &snapshot.Group)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT i.id, i.name, ri.amount, ri.unit
+		FROM ingredients i
+		JOIN recipe_ingredients ri ON i.id = ri.ingredient_id
+		WHERE ri.recipe_id = ?`, recipeID)
+	if err != nil {
+		return err
+	}
+	snapshot.Ingredients = []Ingredient{}
+	for rows.Next() {
+		var ing Ingredient
+		if err := rows.Scan(&ing.ID, &ing.Name, &ing.Amount, &ing.Unit); err != nil {
+			rows.Close()
+			return err
+		}
+		snapshot.Ingredients = append(snapshot.Ingredients, ing)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`
+		SELECT t.id, t.name
+		FROM tags t
+		JOIN recipe_tags rt ON t.id = rt.tag_id
+		WHERE rt.recipe_id = ?
+		ORDER BY t.name COLLATE NOCASE`, recipeID)
+	if err != nil {
+		return err
+	}
+	snapshot.Tags = []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.ID, &tag.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		snapshot.Tags = append(snapshot.Tags, tag)
+	}
+	rows.Close()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO recipe_revisions (recipe_id, number, created_at, snapshot)
+		VALUES (?, (SELECT COALESCE(MAX(number), 0) + 1 FROM recipe_revisions WHERE recipe_id = ?), ?, ?)`,
+		recipeID, recipeID, time.Now().UTC().Format(sqliteTime), string(data),
+	)
+	return err
+}
+
+// recordMissingRevisions gives recipes saved before revisions were kept their
+// first revision.
+func recordMissingRevisions() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM recipes WHERE id NOT IN (SELECT recipe_id FROM recipe_revisions)")
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := recordRevision(tx, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func getRecipeRevisions(recipeID int) ([]RecipeRevision, error) {
+	rows, err := db.Query(
+		"SELECT recipe_id, number, created_at, snapshot FROM recipe_revisions WHERE recipe_id = ? ORDER BY number DESC",
+		recipeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []RecipeRevision{}
+	for rows.Next() {
+		var revision RecipeRevision
+		var snapshot string
+		if err := rows.Scan(&revision.RecipeID, &revision.Number, &revision.CreatedAt, &snapshot); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(snapshot), &revision.Recipe); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func getRecipeRevision(recipeID, number int) (*RecipeRevision, error) {
+	var revision RecipeRevision
+	var snapshot string
+	err := db.QueryRow(
+		"SELECT recipe_id, number, created_at, snapshot FROM recipe_revisions WHERE recipe_id = ? AND number = ?",
+		recipeID, number,
+	).Scan(&revision.RecipeID, &revision.Number, &revision.CreatedAt, &snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(snapshot), &revision.Recipe); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
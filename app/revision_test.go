@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	// Lines "1" to "12"
+	numbers := make([]string, 12)
+	for i := range numbers {
+		numbers[i] = strconv.Itoa(i + 1)
+	}
+	changed := append(append([]string{}, numbers...), "13")
+	changed[2] = "three"
+
+	// Expected output is what diff -u writes for the same lines
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{
+			name: "same",
+			a:    []string{"x", "y"},
+			b:    []string{"x", "y"},
+			want: "",
+		},
+		{
+			name: "created",
+			a:    nil,
+			b:    []string{"x"},
+			want: "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "line added before",
+			a:    []string{"x"},
+			b:    []string{"y", "x"},
+			want: "--- a\n+++ b\n@@ -1 +1,2 @@\n+y\n x\n",
+		},
+		{
+			name: "changes close together share a hunk",
+			a:    numbers[:8],
+			b:    []string{"1", "two", "3", "4", "5", "6", "seven", "8"},
+			want: "--- a\n+++ b\n@@ -1,8 +1,8 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n-7\n+seven\n 8\n",
+		},
+		{
+			name: "two hunks",
+			a:    numbers,
+			b:    changed,
+			want: "--- a\n+++ b\n" +
+				"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+				"@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+13\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			writeUnifiedDiff(&b, "a", "b", tt.a, tt.b)
+			if b.String() != tt.want {
+				t.Errorf("diff =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Browser sessions are kept in the sessions table, keyed by the SHA-256 hash
+// of the token in the cookie so the table alone can't be used to sign in.
+// A session ends after sessionLifetime without being used. The token is
+// replaced every sessionRotateAfter, the old one keeps working for
+// sessionGracePeriod so requests already on their way don't fail.
+const (
+	sessionCookie      = "session"
+	sessionLifetime    = 30 * 24 * time.Hour
+	sessionRotateAfter = time.Hour
+	sessionGracePeriod = time.Minute
+
+	// Format of the session times, the one of SQLite's datetime()
+	sqliteTime = "2006-01-02 15:04:05"
+)
+
+// Password hashes are stored as pbkdf2_sha256$<iterations>$<salt>$<hash>.
+// Rows without that prefix are from before passwords were hashed and hold
+// the password itself.
+const (
+	passwordScheme     = "pbkdf2_sha256"
+	passwordIterations = 600000
+	passwordKeyLength  = 32
+)
+
+// Session is a signed in browser.
+type Session struct {
+	TokenHash string
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// Set once the token was rotated and only lasts the grace period
+	Replaced bool
+}
+
+// hashPassword returns the value stored in users.password for a password.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether password matches the stored hash.
+func checkPassword(stored, password string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, want) == 1
+}
+
+// authenticate returns the ID of the user with the email and password.
+func authenticate(email, password string) (int, error) {
+	var id int
+	var stored string
+	err := db.QueryRow("SELECT id, password FROM users WHERE email = ? COLLATE NOCASE", email).Scan(&id, &stored)
+	if err == sql.ErrNoRows {
+		return 0, errNotAuthenticated
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !checkPassword(stored, password) {
+		return 0, errNotAuthenticated
+	}
+	return id, nil
+}
+
+func hashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// createSession starts a session for the user and sets its cookie.
+func createSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	// Expired sessions are cleaned up whenever someone signs in
+	if _, err := db.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now().UTC().Format(sqliteTime)); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	token := randomToken()
+	expires := now.Add(sessionLifetime)
+	_, err := db.Exec(
+		"INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		hashSessionToken(token), userID, now.Format(sqliteTime), expires.Format(sqliteTime),
+	)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Later calls within the same request see the new session
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != sessionCookie {
+			r.AddCookie(c)
+		}
+	}
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	return nil
+}
+
+// getSession returns the unexpired session of the request's cookie.
+func getSession(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, errNotAuthenticated
+	}
+
+	var session Session
+	var createdAt, expiresAt string
+	err = db.QueryRow(
+		"SELECT token_hash, user_id, created_at, expires_at, replaced_at IS NOT NULL FROM sessions WHERE token_hash = ? AND expires_at > ?",
+		hashSessionToken(cookie.Value), time.Now().UTC().Format(sqliteTime),
+	).Scan(&session.TokenHash, &session.UserID, &createdAt, &expiresAt, &session.Replaced)
+	if err == sql.ErrNoRows {
+		return nil, errNotAuthenticated
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.CreatedAt, _ = time.Parse(sqliteTime, createdAt)
+	session.ExpiresAt, _ = time.Parse(sqliteTime, expiresAt)
+	return &session, nil
+}
+
+// endSession deletes the session of the request and its cookie.
+func endSession(w http.ResponseWriter, r *http.Request) error {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+
+	_, err = db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashSessionToken(cookie.Value))
+	return err
+}
+
+// refreshSessions wraps the site's handler to replace session tokens that
+// are older than sessionRotateAfter, extending the session.
+func refreshSessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := getSession(r)
+		if err == nil && !session.Replaced && time.Since(session.CreatedAt) > sessionRotateAfter {
+			if err := rotateSession(w, r, session); err != nil {
+				log.Printf("Failed to rotate session: %v", err)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rotateSession gives the session a new token. The old token expires after
+// sessionGracePeriod, for requests the browser sent before it got the new one.
+func rotateSession(w http.ResponseWriter, r *http.Request, session *Session) error {
+	now := time.Now().UTC()
+	result, err := db.Exec(
+		"UPDATE sessions SET replaced_at = ?, expires_at = MIN(expires_at, ?) WHERE token_hash = ? AND replaced_at IS NULL",
+		now.Format(sqliteTime), now.Add(sessionGracePeriod).Format(sqliteTime), session.TokenHash,
+	)
+	if err != nil {
+		return err
+	}
+	// Another request rotated it already
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	return createSession(w, r, session.UserID)
+}
+
+// currentUser returns the signed in user of the request, or nil.
+func currentUser(r *http.Request) *User {
+	userID, err := currentUserID(r)
+	if err != nil {
+		return nil
+	}
+	user, err := getUserByID(userID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+func getUserByID(id int) (*User, error) {
+	var user User
+	err := db.QueryRow("SELECT email, name FROM users WHERE id = ?", id).Scan(&user.Email, &user.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
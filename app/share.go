@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// A share link shows one recipe read only to anyone who has it, without an
+// account. Like session tokens, share tokens are stored as their SHA-256 hash,
+// so the token is only known to the user who created the link. Links work
+// until they expire or their creator revokes them.
+
+// Longest a share link can be made to last
+const maxShareDays = 365
+
+// Share is an active share link of a recipe. Token and URL are only known
+// when the link is created.
+type Share struct {
+	ID          int     `json:"id"`
+	RecipeID    int     `json:"recipe_id"`
+	RecipeTitle string  `json:"recipe_title"`
+	Token       string  `json:"token,omitempty"`
+	URL         string  `json:"url,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	ExpiresAt   *string `json:"expires_at"`
+}
+
+type ShareRequest struct {
+	RecipeID      int `json:"recipe_id"`
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// Handler functions
+func sharesHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: " + r.Method + " /api/shares/")
+
+	userID, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	segments := pathSegments(r.URL.Path, "/api/shares/")
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		var recipeID int
+		if param := r.URL.Query().Get("recipe_id"); param != "" {
+			var err error
+			if recipeID, err = strconv.Atoi(param); err != nil {
+				http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+				return
+			}
+		}
+		shares, err := getShares(userID, recipeID)
+		if err != nil {
+			http.Error(w, "Failed to get shares", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shares)
+
+	case len(segments) == 0 && r.Method == http.MethodPost:
+		var req ShareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.ExpiresInDays < 0 || req.ExpiresInDays > maxShareDays {
+			http.Error(w, fmt.Sprintf("expires_in_days must be between 1 and %d, or 0 for a link that doesn't expire", maxShareDays), http.StatusBadRequest)
+			return
+		}
+		if _, err := getRecipeTitle(req.RecipeID); err != nil {
+			http.Error(w, "Recipe not found", http.StatusBadRequest)
+			return
+		}
+
+		// A link makes the recipe public for good, so group recipes are only
+		// shared by the group's editors and owners
+		if err := checkRecipeWrite(userID, req.RecipeID); err == errForbidden {
+			http.Error(w, "Only editors and owners of the recipe's group can share it", http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+			return
+		}
+
+		var expires time.Time
+		if req.ExpiresInDays > 0 {
+			expires = time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+		}
+		share, err := createShare(userID, req.RecipeID, expires)
+		if err != nil {
+			log.Printf("Failed to create share: %v", err)
+			http.Error(w, "Failed to create share", http.StatusInternalServerError)
+			return
+		}
+		share.URL = fmt.Sprintf("%s/shared/%s/", requestBaseURL(r), share.Token)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(share)
+
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		id, err := strconv.Atoi(segments[0])
+		if err != nil {
+			http.Error(w, "Invalid share ID", http.StatusBadRequest)
+			return
+		}
+		err = revokeShare(userID, id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Share not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to revoke share", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) > 1:
+		http.NotFound(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sharedRecipeHandler shows the recipe of a share link read only.
+func sharedRecipeHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: GET /shared/<token>/")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	segments := pathSegments(r.URL.Path, "/shared/")
+	if len(segments) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Keep the token out of search engines and the Referer header of links
+	// on the page
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "private, no-store")
+
+	recipeID, err := getSharedRecipeID(segments[0])
+	if err == sql.ErrNoRows {
+		http.Error(w, "This link doesn't exist, has expired or was revoked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	recipe, err := getRecipeByID(recipeID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "This link doesn't exist, has expired or was revoked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	writeRecipePage(w, r, recipe, true)
+}
+
+// Database helper functions
+
+// createShare creates a share link of the recipe, which doesn't expire if
+// expires is zero.
+func createShare(userID, recipeID int, expires time.Time) (*Share, error) {
+	now := time.Now().UTC()
+
+	// Expired links are cleaned up whenever a new one is made
+	if _, err := db.Exec("DELETE FROM recipe_shares WHERE expires_at <= ?", now.Format(sqliteTime)); err != nil {
+		return nil, err
+	}
+
+	share := &Share{
+		RecipeID:  recipeID,
+		Token:     randomToken(),
+		CreatedAt: now.Format(sqliteTime),
+	}
+	if !expires.IsZero() {
+		expiresAt := expires.UTC().Format(sqliteTime)
+		share.ExpiresAt = &expiresAt
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO recipe_shares (token_hash, recipe_id, user_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		hashSessionToken(share.Token), recipeID, userID, share.CreatedAt, share.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	share.ID = int(id)
+
+	share.RecipeTitle, err = getRecipeTitle(recipeID)
+	return share, err
+}
+
+// getSharedRecipeID returns the recipe of an unexpired share link.
+func getSharedRecipeID(token string) (int, error) {
+	var recipeID int
+	err := db.QueryRow(
+		"SELECT recipe_id FROM recipe_shares WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > ?)",
+		hashSessionToken(token), time.Now().UTC().Format(sqliteTime),
+	).Scan(&recipeID)
+	return recipeID, err
+}
+
+// getShares returns the unexpired share links the user made, of one recipe
+// unless recipeID is 0, newest first.
+func getShares(userID, recipeID int) ([]Share, error) {
+	rows, err := db.Query(`
+		SELECT s.id, s.recipe_id, r.title, s.created_at, s.expires_at
+		FROM recipe_shares s
+		JOIN recipes r ON r.id = s.recipe_id AND r.deleted_at IS NULL
+		WHERE s.user_id = ? AND (? = 0 OR s.recipe_id = ?) AND (s.expires_at IS NULL OR s.expires_at > ?)
+		ORDER BY s.created_at DESC, s.id DESC`,
+		userID, recipeID, recipeID, time.Now().UTC().Format(sqliteTime),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []Share{}
+	for rows.Next() {
+		var share Share
+		var expiresAt sql.NullString
+		if err := rows.Scan(&share.ID, &share.RecipeID, &share.RecipeTitle, &share.CreatedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			share.ExpiresAt = &expiresAt.String
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// revokeShare deletes a share link of the user, after which it stops working.
+func revokeShare(userID, id int) error {
+	result, err := db.Exec("DELETE FROM recipe_shares WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// Adds and removes ingredient rows on the recipe form. Without JavaScript
+// the "Add ingredient" button posts the form to get more rows.
+document.addEventListener("DOMContentLoaded", function () {
+    var list = document.getElementById("ingredient-list");
+    var template = document.getElementById("ingredient-row-template");
+    var addButton = document.querySelector(".recipe-form .add-row");
+    if (!list || !template || !addButton) {
+        return;
+    }
+
+    function enableRemove(row) {
+        var button = row.querySelector(".remove-row");
+        button.hidden = false;
+        button.addEventListener("click", function () {
+            var next = row.nextElementSibling || row.previousElementSibling;
+            row.remove();
+            if (next) {
+                next.querySelector("input").focus();
+            } else {
+                addButton.focus();
+            }
+        });
+    }
+
+    list.querySelectorAll(".ingredient-row").forEach(enableRemove);
+
+    addButton.type = "button";
+    addButton.addEventListener("click", function () {
+        var row = template.content.querySelector(".ingredient-row").cloneNode(true);
+        list.appendChild(row);
+        enableRemove(row);
+        row.querySelector("input").focus();
+    });
+});
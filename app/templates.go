@@ -0,0 +1,377 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Templates, static files and themes are built into the binary. With -dev
+// they are read from disk instead and changed templates are reloaded.
+//
+// Themes live in themes/<name>/. A theme's templates/ directory overrides
+// or adds to the shared templates, its static/ directory holds the theme.css
+// every theme provides, served under /static/themes/<name>/.
+//
+//go:embed templates static themes
+var embeddedFiles embed.FS
+
+var (
+	devMode bool
+
+	sharedTemplates fs.FS
+	themeFiles      fs.FS
+
+	// Guards templates, which are replaced when they change in -dev mode
+	templatesMu       sync.RWMutex
+	templatesLoadedAt time.Time
+
+	// Names of the available themes, and the one visitors get unless they
+	// picked another
+	themes       []string
+	defaultTheme = "modern"
+)
+
+const (
+	themeCookie = "theme"
+	flashCookie = "flash"
+)
+
+// templateFuncs are available in every template. "theme" is set to the name
+// of the theme the templates are parsed for, the request specific ones when a
+// page is rendered.
+var templateFuncs = template.FuncMap{
+	"add":    func(a, b int) int { return a + b },
+	"dict":   templateDict,
+	"split":  strings.Split,
+	"join":   strings.Join,
+	"steps":  recipeSteps,
+	"stars":  stars,
+	"theme":  func() string { return defaultTheme },
+	"themes": func() []string { return themes },
+
+	// Set per request by renderPage
+	"csrfToken":   func() string { return "" },
+	"flash":       func() string { return "" },
+	"currentUser": func() *User { return nil },
+	"currentPath": func() string { return "/" },
+}
+
+// templateDict builds a map from key and value pairs, to pass several values
+// to a template: {{template "field" (dict "Name" "title" "Value" .Title)}}.
+func templateDict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict needs key and value pairs")
+	}
+	m := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// listThemes returns the names of the theme directories in fsys.
+func listThemes(fsys fs.FS) ([]string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// loadThemes makes the themes in themeFS available, with shared holding the
+// templates they have in common.
+func loadThemes(shared, themeFS fs.FS) error {
+	names, err := listThemes(themeFS)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(names, defaultTheme) {
+		return fmt.Errorf("unknown theme %q, available themes: %s", defaultTheme, strings.Join(names, ", "))
+	}
+
+	sets, err := loadTemplates(shared, themeFS, names)
+	if err != nil {
+		return err
+	}
+
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	sharedTemplates, themeFiles, themes, templates = shared, themeFS, names, sets
+	templatesLoadedAt = time.Now()
+	return nil
+}
+
+// reloadChangedTemplates loads the templates again when a template file was
+// modified since they were last loaded.
+func reloadChangedTemplates() error {
+	templatesMu.RLock()
+	shared, themeFS, loadedAt := sharedTemplates, themeFiles, templatesLoadedAt
+	templatesMu.RUnlock()
+
+	changed := false
+	for _, fsys := range []fs.FS{shared, themeFS} {
+		err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || changed {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(loadedAt) {
+				changed = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	log.Println("Templates changed, reloading")
+	return loadThemes(shared, themeFS)
+}
+
+// loadTemplates parses every page for every theme, keyed by theme and page
+// name. A page is base.html, the partials/ and the page itself, each taken
+// from the theme if it has its own version. Pages fill in the "title", "head"
+// and "content" blocks of base.html.
+func loadTemplates(shared, themeFS fs.FS, names []string) (map[string]map[string]*template.Template, error) {
+	sets := map[string]map[string]*template.Template{}
+
+	for _, theme := range names {
+		themeTemplates, err := fs.Sub(themeFS, path.Join(theme, "templates"))
+		if err != nil {
+			return nil, err
+		}
+
+		pageNames, err := templatePages(shared, themeTemplates)
+		if err != nil {
+			return nil, err
+		}
+
+		sets[theme] = map[string]*template.Template{}
+		for _, name := range pageNames {
+			page := template.New("base.html").Funcs(templateFuncs).Funcs(template.FuncMap{
+				"theme": func() string { return theme },
+			})
+
+			// Shared files first so the theme's definitions replace them
+			for _, fsys := range []fs.FS{shared, themeTemplates} {
+				if page, err = parseTemplateGlobs(page, fsys, "base.html", "partials/*.html"); err != nil {
+					return nil, err
+				}
+			}
+			if exists(themeTemplates, name) {
+				page, err = page.ParseFS(themeTemplates, name)
+			} else {
+				page, err = page.ParseFS(shared, name)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			sets[theme][name] = page
+		}
+	}
+
+	return sets, nil
+}
+
+// templatePages returns the names of the pages of the shared templates and
+// of a theme, leaving out the layout.
+func templatePages(shared, theme fs.FS) ([]string, error) {
+	var names []string
+	for _, fsys := range []fs.FS{shared, theme} {
+		files, err := fs.Glob(fsys, "*.html")
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file != "base.html" && !slices.Contains(names, file) {
+				names = append(names, file)
+			}
+		}
+	}
+	return names, nil
+}
+
+// parseTemplateGlobs parses the files matching the patterns, skipping
+// patterns without matches, which ParseFS would report as an error.
+func parseTemplateGlobs(t *template.Template, fsys fs.FS, patterns ...string) (*template.Template, error) {
+	for _, pattern := range patterns {
+		files, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if t, err = t.ParseFS(fsys, files...); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func exists(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
+
+// renderPage executes a page in the layout of the visitor's theme.
+func renderPage(w http.ResponseWriter, r *http.Request, name string, data any) {
+	renderPageStatus(w, r, http.StatusOK, name, data)
+}
+
+// renderPageStatus is renderPage with another status than 200 OK, like 422
+// for a form with errors.
+func renderPageStatus(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	if devMode {
+		if err := reloadChangedTemplates(); err != nil {
+			log.Printf("Template loading error: %v", err)
+			http.Error(w, "Failed to load templates: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	templatesMu.RLock()
+	page, ok := templates[requestTheme(w, r)][name]
+	templatesMu.RUnlock()
+	if !ok {
+		http.Error(w, "Failed to render template: no page "+name, http.StatusInternalServerError)
+		return
+	}
+
+	// The loaded templates are never executed themselves so they can be
+	// cloned with the functions for this request. Cookies are set here,
+	// before the body is written.
+	token, message, user := csrfToken(w, r), takeFlash(w, r), currentUser(r)
+	page, err := page.Clone()
+	if err == nil {
+		page.Funcs(template.FuncMap{
+			"csrfToken":   func() string { return token },
+			"flash":       func() string { return message },
+			"currentUser": func() *User { return user },
+			"currentPath": r.URL.RequestURI,
+		})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		err = page.ExecuteTemplate(w, "base.html", data)
+	}
+	if err != nil {
+		log.Printf("Template execution error: %v", err)
+		http.Error(w, "Failed to render template: "+err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// setFlash stores a message shown on the next page the visitor sees, after
+// the redirect that follows a form submission.
+func setFlash(w http.ResponseWriter, message string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    url.QueryEscape(message),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// takeFlash returns the message set with setFlash and removes it.
+func takeFlash(w http.ResponseWriter, r *http.Request) string {
+	cookie, err := r.Cookie(flashCookie)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: "/", MaxAge: -1})
+
+	message, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return ""
+	}
+	return message
+}
+
+// requestTheme returns the theme picked with the theme query parameter,
+// which is remembered in a cookie, or the one from an earlier visit.
+func requestTheme(w http.ResponseWriter, r *http.Request) string {
+	if theme := r.URL.Query().Get("theme"); slices.Contains(themes, theme) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     themeCookie,
+			Value:    theme,
+			Path:     "/",
+			MaxAge:   365 * 24 * 60 * 60,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return theme
+	}
+	if cookie, err := r.Cookie(themeCookie); err == nil && slices.Contains(themes, cookie.Value) {
+		return cookie.Value
+	}
+	return defaultTheme
+}
+
+// themeStaticHandler serves /static/themes/<name>/<file> from the static
+// directory of the theme.
+func themeStaticHandler(w http.ResponseWriter, r *http.Request) {
+	theme, _, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/static/themes/"), "/")
+	if !ok || !slices.Contains(themes, theme) {
+		http.NotFound(w, r)
+		return
+	}
+
+	templatesMu.RLock()
+	themeFS := themeFiles
+	templatesMu.RUnlock()
+
+	static, err := fs.Sub(themeFS, path.Join(theme, "static"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.StripPrefix("/static/themes/"+theme, http.FileServer(http.FS(static))).ServeHTTP(w, r)
+}
+
+// siteFiles returns the shared templates, static files and themes, from disk
+// in -dev mode and from the binary otherwise.
+func siteFiles() (shared, static, themeFS fs.FS, err error) {
+	if devMode {
+		return os.DirFS("templates"), os.DirFS("static"), os.DirFS("themes"), nil
+	}
+
+	if shared, err = fs.Sub(embeddedFiles, "templates"); err != nil {
+		return nil, nil, nil, err
+	}
+	if static, err = fs.Sub(embeddedFiles, "static"); err != nil {
+		return nil, nil, nil, err
+	}
+	if themeFS, err = fs.Sub(embeddedFiles, "themes"); err != nil {
+		return nil, nil, nil, err
+	}
+	return shared, static, themeFS, nil
+}
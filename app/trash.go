@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Deleted recipes go to the trash first. They are hidden everywhere but can
+// be restored for trashRetention, after which purgeTrash removes them and
+// everything that refers to them for good.
+const (
+	trashRetention     = 30 * 24 * time.Hour
+	trashPurgeInterval = time.Hour
+)
+
+// TrashedRecipe is a recipe in the trash.
+type TrashedRecipe struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+	DeletedAt string `json:"deleted_at"`
+	PurgeAt   string `json:"purge_at"`
+}
+
+// Handler functions
+func recipeDestroyHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: DELETE /api/recipe/recipes/<id>/")
+
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+	if !requireRecipeWrite(w, r, id) {
+		return
+	}
+
+	err = trashRecipe(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete recipe: %v", err)
+		http.Error(w, "Failed to delete recipe", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func recipeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Route invoked: GET /api/recipe/recipes/trash/")
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	recipes, err := getTrashedRecipes()
+	if err != nil {
+		http.Error(w, "Failed to get trash", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recipes)
+}
+
+func recipeRestoreHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: POST /api/recipe/recipes/<id>/restore/")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+	if _, ok := requireUser(w, r); !ok {
+		return
+	}
+	if !requireRecipeWrite(w, r, id) {
+		return
+	}
+
+	err = restoreRecipe(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not in the trash", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to restore recipe: %v", err)
+		http.Error(w, "Failed to restore recipe", http.StatusInternalServerError)
+		return
+	}
+
+	recipe, err := getRecipeByID(id)
+	if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recipe)
+}
+
+// purgeTrashPeriodically empties the expired part of the trash now and then
+// every trashPurgeInterval. It runs for as long as the server does.
+func purgeTrashPeriodically() {
+	for {
+		purged, err := purgeTrash(time.Now())
+		if err != nil {
+			log.Printf("Failed to purge trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d recipes from the trash", purged)
+		}
+		time.Sleep(trashPurgeInterval)
+	}
+}
+
+// Database helper functions
+
+// trashRecipe moves a recipe to the trash.
+func trashRecipe(id int) error {
+	result, err := db.Exec(
+		"UPDATE recipes SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL",
+		time.Now().UTC().Format(sqliteTime), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// restoreRecipe takes a recipe out of the trash, unless it has been there for
+// longer than trashRetention.
+func restoreRecipe(id int) error {
+	result, err := db.Exec(
+		"UPDATE recipes SET deleted_at = NULL WHERE id = ? AND deleted_at > ?",
+		id, time.Now().Add(-trashRetention).UTC().Format(sqliteTime),
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// getTrashedRecipes returns the restorable recipes in the trash, most
+// recently deleted first.
+func getTrashedRecipes() ([]TrashedRecipe, error) {
+	rows, err := db.Query(
+		"SELECT id, title, deleted_at FROM recipes WHERE deleted_at > ? ORDER BY deleted_at DESC, id DESC",
+		time.Now().Add(-trashRetention).UTC().Format(sqliteTime),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipes := []TrashedRecipe{}
+	for rows.Next() {
+		var recipe TrashedRecipe
+		if err := rows.Scan(&recipe.ID, &recipe.Title, &recipe.DeletedAt); err != nil {
+			return nil, err
+		}
+		if deletedAt, err := time.Parse(sqliteTime, recipe.DeletedAt); err == nil {
+			recipe.PurgeAt = deletedAt.Add(trashRetention).Format(sqliteTime)
+		}
+		recipes = append(recipes, recipe)
+	}
+	return recipes, rows.Err()
+}
+
+// purgeTrash removes the recipes that have been in the trash for longer than
+// trashRetention at now, and returns how many there were.
+func purgeTrash(now time.Time) (int, error) {
+	rows, err := db.Query(
+		"SELECT id FROM recipes WHERE deleted_at <= ?",
+		now.Add(-trashRetention).UTC().Format(sqliteTime),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for i, id := range ids {
+		if err := purgeRecipe(id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
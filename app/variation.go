@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A variation is a copy of a recipe that remembers the recipe it was copied
+// from, its parent, so "Chicken Parmesan (gluten-free)" links back to
+// "Chicken Parmesan". After copying the two are edited independently. The
+// copy of a group recipe stays in the group if the user making it is an
+// editor or owner there, and has no group otherwise.
+
+type VariationRequest struct {
+	Title string `json:"title"`
+}
+
+// Handler functions
+func recipeVariationsHandler(w http.ResponseWriter, r *http.Request, idParam string) {
+	fmt.Println("Route invoked: " + r.Method + " /api/recipe/recipes/<id>/variations/")
+
+	parentID, err := strconv.Atoi(idParam)
+	if err != nil {
+		http.Error(w, "Invalid recipe ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := getRecipeTitle(parentID); err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		variations, err := getVariations(parentID)
+		if err != nil {
+			http.Error(w, "Failed to get variations", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(variations)
+
+	case http.MethodPost:
+		userID, ok := requireUser(w, r)
+		if !ok {
+			return
+		}
+
+		// The body is optional, without a title the copy gets a default one
+		var req VariationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		id, err := createVariation(parentID, strings.TrimSpace(req.Title), userID)
+		if err != nil {
+			log.Printf("Failed to create variation: %v", err)
+			http.Error(w, "Failed to create variation", http.StatusInternalServerError)
+			return
+		}
+		recipe, err := getRecipeByID(id)
+		if err != nil {
+			http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(recipe)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// recipeVariationFormHandler copies a recipe with the button on its page and
+// opens the copy in the edit form, to change it into the variation.
+func recipeVariationFormHandler(w http.ResponseWriter, r *http.Request, parentID int) {
+	fmt.Println("Route invoked: POST /recipes/<id>/variation/")
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validCSRF(r) {
+		http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+	userID, ok := requirePageUser(w, r)
+	if !ok {
+		return
+	}
+
+	title, err := getRecipeTitle(parentID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recipe not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get recipe", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := createVariation(parentID, "", userID)
+	if err != nil {
+		log.Printf("Failed to create variation: %v", err)
+		http.Error(w, "Failed to create variation", http.StatusInternalServerError)
+		return
+	}
+
+	setFlash(w, fmt.Sprintf("Copied %q, change the copy into your variation below.", title))
+	http.Redirect(w, r, fmt.Sprintf("/recipes/%d/edit/", id), http.StatusSeeOther)
+}
+
+// Database helper functions
+
+// createVariation copies a recipe with its ingredients and tags into a new
+// recipe based on it, titled "<title> (variation)" unless a title is given.
+// The copy is in the recipe's group if the user can add recipes to it.
+func createVariation(parentID int, title string, userID int) (int, error) {
+	parent, err := getRecipeByID(parentID)
+	if err != nil {
+		return 0, err
+	}
+	if title == "" {
+		title = parent.Title + " (variation)"
+	}
+
+	groupID := parent.GroupID
+	if err := checkGroupRole(userID, groupID, "editor"); err == errForbidden {
+		groupID = 0
+	} else if err != nil {
+		return 0, err
+	}
+
+	return createRecipe(&Recipe{
+		Title:          title,
+		TimeMinutes:    parent.TimeMinutes,
+		Price:          parent.Price,
+		Link:           parent.Link,
+		Description:    parent.Description,
+		Servings:       parent.Servings,
+		Ingredients:    parent.Ingredients,
+		Tags:           parent.Tags,
+		ParentRecipeID: parent.ID,
+		GroupID:        groupID,
+	})
+}
+
+// getVariations returns the recipes based on a recipe, by title.
+func getVariations(parentID int) ([]RecipeSimple, error) {
+	variations, err := queryRecipesSimple(`
+		SELECT id, title, time_minutes, price, link
+		FROM recipes
+		WHERE parent_recipe_id = ? AND deleted_at IS NULL
+		ORDER BY title COLLATE NOCASE`, parentID)
+	if variations == nil && err == nil {
+		variations = []RecipeSimple{}
+	}
+	return variations, err
+}